@@ -2,20 +2,34 @@ package main
 
 import (
 	"container/heap"
+	"errors"
 	"fmt"
 )
 
+// ErrBalancerStopped is returned for requests which were not performed because the balancer was stopped
+var ErrBalancerStopped = errors.New("The worker pool has been stopped")
+
 // GranuleRequest is used to fetch all image urls from a single granule
 type GranuleRequest struct {
-	fn  func(url string) GranuleResult
-	url string
-	ch  chan GranuleResult
+	fn    func(url string) GranuleResult
+	url   string
+	index int // position of the request, copied to its result
+	ch    chan GranuleResult
 }
 
 // GranuleResult holds the result of each GranuleRequest
 type GranuleResult struct {
-	urls []string
-	err  error
+	urls    []string
+	tile    *TileMetadata
+	mask    *CloudMask
+	product *ProductMetadata
+	index   int
+	err     error
+
+	// Manifest of a product
+	manifest Manifest
+	// Object names of the images of a granule, in the order of urls
+	objects []string
 }
 
 // GranuleWorker is a worker performing GranuleRequests
@@ -25,15 +39,38 @@ type GranuleWorker struct {
 	index    int                 //index in the heap
 }
 
-// Execute a request from the channel of requests
-func (w *GranuleWorker) work(done chan *GranuleWorker) {
+// Execute requests from the channel of requests until the balancer is stopped
+// The channel of every request must be buffered for all its results, so that the worker never blocks on it.
+func (w *GranuleWorker) work(done chan *GranuleWorker, quit chan struct{}) {
 	for {
-		req := <-w.requests
-		req.ch <- req.fn(req.url)
-		done <- w
+		select {
+		case req := <-w.requests:
+			res := perform(req)
+			res.index = req.index
+			req.ch <- res
+
+			select {
+			case done <- w:
+			case <-quit:
+				return
+			}
+		case <-quit:
+			return
+		}
 	}
 }
 
+// Perform a request, turning a panic into an error so that the worker keeps running
+func perform(req GranuleRequest) (res GranuleResult) {
+	defer func() {
+		if p := recover(); p != nil {
+			res = GranuleResult{err: fmt.Errorf("request for %s failed: %v", req.url, p)}
+		}
+	}()
+
+	return req.fn(req.url)
+}
+
 // Pool of available workers
 type Pool []*GranuleWorker
 
@@ -57,7 +94,9 @@ func (p Pool) Swap(i, j int) {
 // Push an item to the heap
 func (p *Pool) Push(x interface{}) {
 	a := *p
-	*p = append(a, x.(*GranuleWorker))
+	w := x.(*GranuleWorker)
+	w.index = len(a)
+	*p = append(a, w)
 }
 
 // Pop remove and return an item from the top of a heap
@@ -71,47 +110,64 @@ func (p *Pool) Pop() interface{} {
 }
 
 // Balancer balances the workload among the workers
+// A single balancer is shared by all requests to the server, it runs until it is stopped.
 type Balancer struct {
 	pool Pool
-	done chan *GranuleWorker
+	// Number of requests queued by a single worker
+	capacity int
+	work     chan GranuleRequest
+	done     chan *GranuleWorker
+	quit     chan struct{}
 }
 
-// NewBalancer creates a new balancer with a specific number of workers
+// NewBalancer creates and starts a new balancer with a specific number of workers
 func NewBalancer(nWorker int, nRequesters int) *Balancer {
 	workers := make(Pool, 0, nWorker)
 
 	done := make(chan *GranuleWorker)
+	quit := make(chan struct{})
 
 	for i := 0; i < nWorker; i++ {
 		w := &GranuleWorker{requests: make(chan GranuleRequest, nRequesters), pending: 0}
-		go w.work(done)
+		go w.work(done, quit)
 		heap.Push(&workers, w)
 	}
 
-	return &Balancer{pool: workers, done: done}
+	b := &Balancer{pool: workers, capacity: nRequesters, work: make(chan GranuleRequest), done: done, quit: quit}
+
+	go b.balance()
+
+	return b
 }
 
-// Balance the workload among workers
-func (b Balancer) Balance(work chan GranuleRequest, abort chan error) {
+// Stop the balancer and all its workers, requests not yet dispatched are never performed
+func (b *Balancer) Stop() {
+	close(b.quit)
+}
 
+// Balance the workload among workers until the balancer is stopped
+func (b *Balancer) balance() {
 	for {
+		work := b.work
+
+		// Stop accepting requests while even the least busy worker is full, it would block the balancer
+		if b.pool[0].pending >= b.capacity {
+			work = nil
+		}
+
 		select {
-		case err := <-abort:
-			if err != nil {
-				fmt.Println(err.Error())
-			}
-			// TODO: cancel running routines, if possible
-			return
 		case r := <-work:
 			b.dispatch(r)
 		case w := <-b.done:
 			b.complete(w)
+		case <-b.quit:
+			return
 		}
 	}
 }
 
 // Dispatch a request to the least busy worker
-func (b Balancer) dispatch(r GranuleRequest) {
+func (b *Balancer) dispatch(r GranuleRequest) {
 	w := heap.Pop(&b.pool).(*GranuleWorker)
 	w.pending++
 	w.requests <- r
@@ -119,7 +175,7 @@ func (b Balancer) dispatch(r GranuleRequest) {
 }
 
 // Decrease the number of pending requests for a specific worker
-func (b Balancer) complete(w *GranuleWorker) {
+func (b *Balancer) complete(w *GranuleWorker) {
 	w = heap.Remove(&b.pool, w.index).(*GranuleWorker)
 	w.pending--
 	heap.Push(&b.pool, w)
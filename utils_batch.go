@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// MaxBatchFeatures is the upper bound on the number of features in a single batch request
+const MaxBatchFeatures = 1000
+
+// BatchFeature is a single feature of a batch request identified by its ID
+type BatchFeature struct {
+	ID     string
+	Shapes []Shape
+}
+
+// BatchGranule holds the images of a single granule matching a feature
+type BatchGranule struct {
+	GranuleID string        `json:"granule_id"`
+	Images    []string      `json:"images"`
+	Tile      *TileMetadata `json:"tile,omitempty"`
+}
+
+// BatchResult holds the granules matching a single feature of a batch request
+type BatchResult struct {
+	ID       string         `json:"id"`
+	Granules []BatchGranule `json:"granules"`
+}
+
+// Parse the features of a GeoJSON feature collection, every feature must have a unique ID
+func parseBatchFeatures(data []byte) ([]BatchFeature, error) {
+	var collection geoJSONObject
+
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, err
+	}
+
+	if collection.Type != "FeatureCollection" {
+		return nil, errors.New("Batch requests must be a GeoJSON FeatureCollection")
+	}
+
+	if len(collection.Features) == 0 {
+		return nil, errors.New("The request does not contain any features")
+	}
+
+	if len(collection.Features) > MaxBatchFeatures {
+		return nil, fmt.Errorf("Batch requests are limited to %d features", MaxBatchFeatures)
+	}
+
+	features := make([]BatchFeature, 0, len(collection.Features))
+	seen := make(map[string]bool)
+
+	for i, f := range collection.Features {
+		id, err := featureID(f.ID)
+		if err != nil {
+			return nil, fmt.Errorf("Feature %d %s", i, err.Error())
+		}
+
+		if seen[id] {
+			return nil, fmt.Errorf("Feature id '%s' is not unique", id)
+		}
+		seen[id] = true
+
+		shapes, err := f.shapes()
+		if err != nil {
+			return nil, fmt.Errorf("Feature '%s': %s", id, err.Error())
+		}
+
+		if len(shapes) == 0 {
+			return nil, fmt.Errorf("Feature '%s' does not have a geometry", id)
+		}
+
+		features = append(features, BatchFeature{ID: id, Shapes: shapes})
+	}
+
+	return features, nil
+}
+
+// Decode the id of a GeoJSON feature, which is either a string or a number
+// Numbers are formatted canonically, so that 1 and 1.0 are the same id.
+func featureID(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", errors.New("does not have an id")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var id interface{}
+
+	if err := dec.Decode(&id); err != nil {
+		return "", fmt.Errorf("has an invalid id: %s", err.Error())
+	}
+
+	switch id := id.(type) {
+	case nil:
+		return "", errors.New("does not have an id")
+	case string:
+		if id == "" {
+			return "", errors.New("does not have an id")
+		}
+		return id, nil
+	case json.Number:
+		value, err := id.Float64()
+		if err != nil {
+			return "", fmt.Errorf("has an invalid id: %s", err.Error())
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	}
+
+	return "", errors.New("has an id which is neither a string nor a number")
+}
+
+// Get the bounds searched for a feature
+// A single point is searched like in /image, all other shapes are covered like in /image/area.
+func featureBounds(feature BatchFeature, width float64, opts CoveringOptions) ([]Bounds, error) {
+	if len(feature.Shapes) == 1 && len(feature.Shapes[0].Points) == 1 {
+		p := feature.Shapes[0].Points[0]
+		return pointBounds(p.lat, p.lng), nil
+	}
+
+	cover, err := getShapesCovering(feature.Shapes, width, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cover, err = getClientCovering(&cover, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return coveringBounds(cover), nil
+}
+
+// Search granules for all features of a batch, given the bounds searched for every feature
+// All features are evaluated by a single index query and every distinct granule is listed only once.
+func (s *Server) getBatchImages(features []BatchFeature, bounds [][]Bounds, search SearchOptions) ([]BatchResult, error) {
+	all := make([]Bounds, 0)
+
+	for _, b := range bounds {
+		all = append(all, b...)
+	}
+
+	granules, err := s.getGranules(all)
+	if err != nil {
+		return nil, err
+	}
+
+	// Granules not matching the filters of the search are dropped here
+	images, err := s.searchGranules(granules, search)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, 0, len(features))
+
+	for i, feature := range features {
+		result := BatchResult{ID: feature.ID, Granules: make([]BatchGranule, 0)}
+
+		for _, g := range images {
+			if granuleIntersects(g.Bounds, bounds[i]) {
+				result.Granules = append(result.Granules, BatchGranule{GranuleID: g.GranuleID, Images: g.Images, Tile: g.Tile})
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
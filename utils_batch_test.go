@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFeatureID(t *testing.T) {
+	tests := []struct {
+		raw string
+		id  string
+		ok  bool
+	}{
+		{raw: `"field-1"`, id: "field-1", ok: true},
+		{raw: `"a\"b\\c"`, id: `a"b\c`, ok: true},
+		{raw: `"été"`, id: "été", ok: true},
+		{raw: `"null"`, id: "null", ok: true},
+		{raw: `42`, id: "42", ok: true},
+		{raw: `42.0`, id: "42", ok: true},
+		{raw: `4.2e1`, id: "42", ok: true},
+		{raw: `-0.5`, id: "-0.5", ok: true},
+		{raw: ``},
+		{raw: `null`},
+		{raw: `""`},
+		{raw: `{"a":1}`},
+		{raw: `[1]`},
+		{raw: `true`},
+	}
+
+	for _, test := range tests {
+		id, err := featureID(json.RawMessage(test.raw))
+
+		if test.ok && (err != nil || id != test.id) {
+			t.Errorf("featureID(%s) = %q, %v, expected %q", test.raw, id, err, test.id)
+		}
+
+		if !test.ok && err == nil {
+			t.Errorf("featureID(%s) = %q was accepted", test.raw, id)
+		}
+	}
+}
+
+func TestParseBatchFeaturesDuplicateIDs(t *testing.T) {
+	data := `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "id": 1, "geometry": {"type": "Point", "coordinates": [10, 50]}},
+		{"type": "Feature", "id": 1.0, "geometry": {"type": "Point", "coordinates": [11, 51]}}
+	]}`
+
+	if _, err := parseBatchFeatures([]byte(data)); err == nil {
+		t.Error("duplicate numeric ids were accepted")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/golang/geo/r1"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// Bounds is a latitude/longitude rectangle in degrees
+// Bounds used for searching never cross the antimeridian, see splitBounds.
+type Bounds struct {
+	South float64 `json:"south"`
+	West  float64 `json:"west"`
+	North float64 `json:"north"`
+	East  float64 `json:"east"`
+}
+
+// Check whether two bounds overlap
+func (b Bounds) intersects(o Bounds) bool {
+	return b.South <= o.North && b.North >= o.South && b.West <= o.East && b.East >= o.West
+}
+
+// Get the center of the bounds in degrees, West may be greater than East for bounds crossing the antimeridian
+func (b Bounds) center() (float64, float64) {
+	east := b.East
+
+	if b.West > east {
+		east += 360
+	}
+
+	lng := (b.West + east) / 2
+
+	if lng > 180 {
+		lng -= 360
+	}
+
+	return (b.South + b.North) / 2, lng
+}
+
+// BoundsPredicate is the SQL predicate matching all granules intersecting the bounds b,
+// where b is an element of the @bounds query parameter.
+// Both rectangles have to overlap in latitude as well as in longitude.
+// Granules crossing the antimeridian have west_lon greater than east_lon and wrap around
+const BoundsPredicate = `south_lat <= b.North AND north_lat >= b.South AND (
+			(west_lon <= east_lon AND west_lon <= b.East AND east_lon >= b.West) OR
+			(west_lon > east_lon AND (west_lon <= b.East OR east_lon >= b.West))
+		)`
+
+// Split bounds crossing the antimeridian, given with West greater than East, into two bounds
+func splitBounds(b Bounds) []Bounds {
+	if b.West <= b.East {
+		return []Bounds{b}
+	}
+
+	return []Bounds{
+		{South: b.South, West: b.West, North: b.North, East: 180},
+		{South: b.South, West: -180, North: b.North, East: b.East},
+	}
+}
+
+// Check whether granule bounds, which may cross the antimeridian, intersect any of the bounds
+func granuleIntersects(granule Bounds, bounds []Bounds) bool {
+	parts := splitBounds(granule)
+
+	for _, b := range bounds {
+		for _, p := range parts {
+			if p.intersects(b) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Parse a latitude/longitude coordinate given in degrees
+func parseLatLng(lat string, lng string) (float64, float64, error) {
+	latitude, err := strconv.ParseFloat(lat, 64)
+	if err != nil || math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+		return 0, 0, fmt.Errorf("Latitude '%s' must be a number between -90 and 90", lat)
+	}
+
+	longitude, err := strconv.ParseFloat(lng, 64)
+	if err != nil || math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+		return 0, 0, fmt.Errorf("Longitude '%s' must be a number between -180 and 180", lng)
+	}
+
+	return latitude, longitude, nil
+}
+
+// Get the bounds of a single latitude/longitude coordinate
+func pointBounds(lat float64, lng float64) []Bounds {
+	if math.Abs(lat) == 90 {
+		// All meridians meet at the poles
+		return []Bounds{{South: lat, West: -180, North: lat, East: 180}}
+	}
+
+	return []Bounds{{South: lat, West: lng, North: lat, East: lng}}
+}
+
+// Normalize a box given by two arbitrary corners
+// The corners may be given in any order, the box always spans the shorter way around the globe in longitude.
+// A box crossing the antimeridian is split into two bounds, a box touching a pole spans all longitudes.
+func normalizeBounds(lat1 float64, lng1 float64, lat2 float64, lng2 float64) ([]Bounds, error) {
+	if lat1 == lat2 && lng1 == lng2 {
+		return pointBounds(lat1, lng1), nil
+	}
+
+	south, north := math.Min(lat1, lat2), math.Max(lat1, lat2)
+	west, east := math.Min(lng1, lng2), math.Max(lng1, lng2)
+
+	if south == -90 || north == 90 {
+		return []Bounds{{South: south, West: -180, North: north, East: 180}}, nil
+	}
+
+	if east-west <= 180 {
+		return []Bounds{{South: south, West: west, North: north, East: east}}, nil
+	}
+
+	if east-west == 360 {
+		return nil, errors.New("The longitude span of the box is ambiguous")
+	}
+
+	// The shorter way goes from the eastern corner across the antimeridian to the western corner
+	return []Bounds{
+		{South: south, West: east, North: north, East: 180},
+		{South: south, West: -180, North: north, East: west},
+	}, nil
+}
+
+// Get the s2.Rect of bounds, bounds with West greater than East cross the antimeridian
+func (b Bounds) rect() s2.Rect {
+	return s2.Rect{
+		Lat: r1.Interval{Lo: b.South * math.Pi / 180, Hi: b.North * math.Pi / 180},
+		Lng: s1.Interval{Lo: b.West * math.Pi / 180, Hi: b.East * math.Pi / 180},
+	}
+}
+
+// Get the bounds of an s2.Rect, splitting it at the antimeridian if necessary
+func rectBounds(rect s2.Rect) []Bounds {
+	south, north := rect.Lo().Lat.Degrees(), rect.Hi().Lat.Degrees()
+	west, east := rect.Lo().Lng.Degrees(), rect.Hi().Lng.Degrees()
+
+	if rect.Lng.IsInverted() {
+		return []Bounds{
+			{South: south, West: west, North: north, East: 180},
+			{South: south, West: -180, North: north, East: east},
+		}
+	}
+
+	return []Bounds{{South: south, West: west, North: north, East: east}}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"container/list"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// MetadataCache caches parsed metadata as JSON, in memory up to a number of bytes and optionally on disk
+// Sentinel-2 products never change once published, so entries do not expire.
+// The least recently used entries are evicted from memory, the disk store is never evicted.
+type MetadataCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	// Front of the list is the most recently used entry
+	order   *list.List
+	entries map[string]*list.Element
+	dir     string
+	stats   CacheStats
+	// Number of purges, used to detect a purge while an entry is read from disk
+	purges uint64
+}
+
+// CacheStats holds the metrics of a cache
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	DiskHits  uint64 `json:"disk_hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"max_bytes"`
+}
+
+// Entry of the memory tier
+type cacheEntry struct {
+	key  string
+	data []byte
+}
+
+// NewMetadataCache creates a cache holding up to maxBytes in memory, persisting entries in dir unless it is empty
+func NewMetadataCache(maxBytes int64, dir string) (*MetadataCache, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &MetadataCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		dir:      dir,
+	}, nil
+}
+
+// Key of a kind of metadata of a granule
+// Keys start with the granule id, so that all entries of a granule share a prefix.
+func metadataKey(granuleID string, kind string) string {
+	return granuleID + "/" + kind
+}
+
+// Get an entry, decoding it into value
+// Entries found on disk only are loaded into memory, the disk is read without holding the lock.
+func (c *MetadataCache) get(key string, value interface{}) bool {
+	c.mu.Lock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+
+		if err := json.Unmarshal(elem.Value.(*cacheEntry).data, value); err == nil {
+			c.stats.Hits++
+			c.mu.Unlock()
+			return true
+		}
+	}
+
+	purges := c.purges
+	c.mu.Unlock()
+
+	if c.dir != "" {
+		data, err := ioutil.ReadFile(c.path(key))
+
+		if err == nil && json.Unmarshal(data, value) == nil {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			c.stats.DiskHits++
+
+			// An entry purged while it was read is not loaded into memory again
+			if c.purges == purges {
+				c.add(key, data)
+			}
+			return true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Misses++
+
+	return false
+}
+
+// Cache a value, a value which cannot be encoded is not cached
+func (c *MetadataCache) put(key string, value interface{}) {
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		log.Printf("Metadata cache: %s", err.Error())
+		return
+	}
+
+	c.mu.Lock()
+	c.add(key, data)
+	c.mu.Unlock()
+
+	if c.dir == "" {
+		return
+	}
+
+	// Write to a unique temporary file first, so that a crash or a concurrent put never leaves a partial entry
+	tmp, err := ioutil.TempFile(c.dir, "entry-*.tmp")
+
+	if err != nil {
+		log.Printf("Metadata cache: %s", err.Error())
+		return
+	}
+
+	_, err = tmp.Write(data)
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Metadata cache: %s", err.Error())
+	}
+}
+
+// Add an entry to the memory tier, evicting the least recently used entries beyond the size limit
+// An entry larger than the whole memory tier is not kept in memory.
+func (c *MetadataCache) add(key string, data []byte) {
+	c.remove(key)
+
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, data: data})
+	c.bytes += int64(len(data))
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.order.Back().Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// Remove an entry from the memory tier
+func (c *MetadataCache) remove(key string) {
+	elem, ok := c.entries[key]
+
+	if !ok {
+		return
+	}
+
+	c.order.Remove(elem)
+	delete(c.entries, key)
+	c.bytes -= int64(len(elem.Value.(*cacheEntry).data))
+}
+
+// Purge all entries whose key starts with a prefix from both tiers, returning the number of purged entries
+func (c *MetadataCache) purge(prefix string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purges++
+	purged := make(map[string]bool)
+
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(key)
+			purged[key] = true
+		}
+	}
+
+	if c.dir == "" {
+		return len(purged), nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+
+	if err != nil {
+		return len(purged), err
+	}
+
+	for _, file := range files {
+		key, err := c.diskKey(file)
+
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if err := os.Remove(file); err != nil {
+			return len(purged), err
+		}
+
+		purged[key] = true
+	}
+
+	return len(purged), nil
+}
+
+// Get the metrics of the cache
+func (c *MetadataCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	stats.Bytes = c.bytes
+	stats.MaxBytes = c.maxBytes
+
+	return stats
+}
+
+// Get the path of the file of an entry in the disk store
+// Keys are hex encoded, which keeps them reversible and safe to use as file names.
+func (c *MetadataCache) path(key string) string {
+	return filepath.Join(c.dir, hex.EncodeToString([]byte(key))+".json")
+}
+
+// Get the key of a file of the disk store
+func (c *MetadataCache) diskKey(file string) (string, error) {
+	key, err := hex.DecodeString(strings.TrimSuffix(filepath.Base(file), ".json"))
+
+	return string(key), err
+}
@@ -0,0 +1,118 @@
+package main
+
+import "testing"
+
+func TestMetadataCache(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		c, err := NewMetadataCache(1024, dir)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.put(metadataKey("A", "tile"), "tile of A")
+
+		var value string
+
+		if !c.get(metadataKey("A", "tile"), &value) || value != "tile of A" {
+			t.Errorf("dir %q: got %q", dir, value)
+		}
+
+		if c.get(metadataKey("A", "product"), &value) {
+			t.Errorf("dir %q: missing entry found", dir)
+		}
+
+		// Entries larger than the memory tier are kept on disk only
+		large := string(make([]byte, 2048))
+		c.put(metadataKey("B", "tile"), large)
+
+		if ok := c.get(metadataKey("B", "tile"), &value); ok != (dir != "") {
+			t.Errorf("dir %q: large entry found %t", dir, ok)
+		}
+
+		if stats := c.Stats(); stats.Hits != 1 || stats.Entries != 1 || stats.Bytes > stats.MaxBytes {
+			t.Errorf("dir %q: stats %+v", dir, stats)
+		}
+	}
+}
+
+func TestMetadataCacheEviction(t *testing.T) {
+	c, err := NewMetadataCache(40, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every entry takes 12 bytes, so the memory tier holds 3 of them
+	for _, id := range []string{"A", "B", "C"} {
+		c.put(metadataKey(id, "tile"), "0123456789")
+	}
+
+	var value string
+
+	// A becomes the most recently used entry, so B is evicted first
+	c.get(metadataKey("A", "tile"), &value)
+	c.put(metadataKey("D", "tile"), "0123456789")
+
+	if !c.get(metadataKey("A", "tile"), &value) || c.get(metadataKey("B", "tile"), &value) {
+		t.Error("evicted an entry other than the least recently used")
+	}
+
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 3 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestMetadataCachePurge(t *testing.T) {
+	productPath := "tiles/33/U/WP/S2A_MSIL1C_20200101T100000_N0208_R122_T33UWP_20200101T120000.SAFE/"
+
+	for _, dir := range []string{"", t.TempDir()} {
+		c, err := NewMetadataCache(1024, dir)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := []string{
+			metadataKey("L1C_T33UWP_A000001_20200101T100000", "tile"),
+			metadataKey("L1C_T33UWP_A000001_20200101T100000", "details"),
+			metadataKey("L1C_T33UWP_A000002_20200101T100000", "tile"),
+			metadataKey(productPath, "manifest"),
+		}
+
+		for _, key := range keys {
+			c.put(key, key)
+		}
+
+		purged, err := c.purge(metadataKey("L1C_T33UWP_A000001_20200101T100000", ""))
+
+		if err != nil || purged != 2 {
+			t.Errorf("dir %q: purged %d entries of the granule, error %v", dir, purged, err)
+		}
+
+		purged, err = c.purge(metadataKey(productPath, ""))
+
+		if err != nil || purged != 1 {
+			t.Errorf("dir %q: purged %d entries of the product, error %v", dir, purged, err)
+		}
+
+		var value string
+
+		for i, key := range keys {
+			// Purged entries are neither in memory nor on disk
+			if found := c.get(key, &value); found != (i == 2) {
+				t.Errorf("dir %q: %s found %t", dir, key, found)
+			}
+		}
+
+		purged, err = c.purge("")
+
+		if err != nil || purged != 1 || c.Stats().Entries != 0 {
+			t.Errorf("dir %q: purged %d entries of all, error %v", dir, purged, err)
+		}
+
+		if c.get(keys[2], &value) {
+			t.Errorf("dir %q: %s found after purging all entries", dir, keys[2])
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/golang/geo/s2"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// CloudMask holds the cloud polygons of a granule (MSK_CLOUDS_B00.gml)
+type CloudMask struct {
+	Clouds []CloudPolygon
+}
+
+// CloudPolygon is a single polygon of a cloud mask
+type CloudPolygon struct {
+	// Type of the cloud, OPAQUE or CIRRUS
+	Type    string
+	Polygon *s2.Polygon
+}
+
+// Layout of MSK_CLOUDS_B00.gml, elements are matched regardless of their namespace
+type cloudMaskXML struct {
+	Envelope struct {
+		SRSName string `xml:"srsName,attr"`
+	} `xml:"boundedBy>Envelope"`
+	Features []struct {
+		Type    string `xml:"maskType"`
+		Polygon struct {
+			SRSName   string       `xml:"srsName,attr"`
+			Exterior  gmlPosList   `xml:"exterior>LinearRing>posList"`
+			Interiors []gmlPosList `xml:"interior>LinearRing>posList"`
+		} `xml:"extentOf>Polygon"`
+	} `xml:"maskMembers>MaskFeature"`
+}
+
+// List of coordinates of a GML ring
+type gmlPosList struct {
+	Dimension int    `xml:"srsDimension,attr"`
+	Values    string `xml:",chardata"`
+}
+
+// Get the points of a ring given in UTM coordinates in degrees
+func (l gmlPosList) points(zone UTMZone) ([]Point, error) {
+	dimension := l.Dimension
+
+	if dimension == 0 {
+		dimension = 2
+	}
+
+	values := strings.Fields(l.Values)
+
+	if dimension < 2 || len(values)%dimension != 0 {
+		return nil, errors.New("Invalid GML position list")
+	}
+
+	points := make([]Point, 0, len(values)/dimension)
+
+	for i := 0; i < len(values); i += dimension {
+		x, err := strconv.ParseFloat(values[i], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		lat, lng := zone.inverse(x, y)
+
+		points = append(points, Point{lat: lat, lng: lng})
+	}
+
+	return points, nil
+}
+
+// Get the EPSG code of a GML srsName such as "urn:ogc:def:crs:EPSG:8.8.1:32633"
+func epsgFromSRSName(name string) string {
+	i := strings.LastIndexAny(name, ":#")
+
+	return "EPSG:" + name[i+1:]
+}
+
+// Parse a cloud mask, polygons which are not valid are skipped
+func parseCloudMask(data []byte) (*CloudMask, error) {
+	var doc cloudMaskXML
+
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	mask := &CloudMask{Clouds: make([]CloudPolygon, 0, len(doc.Features))}
+
+	for _, feature := range doc.Features {
+		srsName := feature.Polygon.SRSName
+
+		if srsName == "" {
+			srsName = doc.Envelope.SRSName
+		}
+
+		zone, err := parseUTMZone(epsgFromSRSName(srsName))
+
+		if err != nil {
+			return nil, err
+		}
+
+		exterior, err := maskLoop(feature.Polygon.Exterior, zone)
+
+		if err != nil {
+			log.Printf("Skipping cloud polygon: %s", err.Error())
+			continue
+		}
+
+		loops := []*s2.Loop{exterior}
+
+		// An invalid hole is dropped, which only overestimates the clouds
+		for _, interior := range feature.Polygon.Interiors {
+			if hole, err := maskLoop(interior, zone); err == nil {
+				loops = append(loops, hole)
+			}
+		}
+
+		mask.Clouds = append(mask.Clouds, CloudPolygon{Type: feature.Type, Polygon: s2.PolygonFromLoops(loops)})
+	}
+
+	return mask, nil
+}
+
+// Build a loop from a ring of a mask
+// Holes are oriented counter-clockwise as well, the polygon identifies them by their nesting.
+func maskLoop(ring gmlPosList, zone UTMZone) (*s2.Loop, error) {
+	points, err := ring.points(zone)
+
+	if err != nil {
+		return nil, err
+	}
+
+	points, err = normalizeRing(points)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return polygonFromPoints(points).Loop(0), nil
+}
+
+// Get the fraction of an AOI within the bounds of a granule which is covered by clouds
+// The second return value is false when the granule does not have a cloud mask or the AOI does not overlap the granule.
+func (m *CloudMask) aoiFraction(aoi s2.CellUnion, bounds Bounds) (float64, bool) {
+	if m == nil {
+		return 0, false
+	}
+
+	polygons := make([]*s2.Polygon, 0, len(m.Clouds))
+
+	for _, cloud := range m.Clouds {
+		polygons = append(polygons, cloud.Polygon)
+	}
+
+	return aoiFraction(aoi, bounds, polygons)
+}
+
+// Get the cloud mask in the quality indicator directory of a granule, nil if the granule does not have a vector cloud mask
+// Products of processing baseline 04.00 and later ship raster masks only (MSK_CLASSI_B00.jp2).
+func (s *Server) getCloudMask(qualityPath string) (*CloudMask, error) {
+	ctx := context.Background()
+
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: qualityPath})
+
+	for {
+		objAttrs, err := it.Next()
+
+		if err == iterator.Done {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Base(objAttrs.Name)
+
+		// The mask is called MSK_CLOUDS_B00.gml, or S2A_OPER_MSK_CLOUDS_..._B00_MSIL1C.gml before 2016
+		if !strings.Contains(name, "MSK_CLOUDS_") || !strings.Contains(name, "B00") || path.Ext(name) != ".gml" {
+			continue
+		}
+
+		checksum, err := s.fileChecksum(objAttrs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := downloadVerified(objAttrs.MediaLink, checksum)
+
+		if err != nil {
+			return nil, err
+		}
+
+		mask, err := parseCloudMask(data)
+
+		// A broken mask only leaves the cloud fraction of its granule unknown
+		if err != nil {
+			log.Printf("Skipping cloud mask %s: %s", objAttrs.Name, err.Error())
+			return nil, nil
+		}
+
+		return mask, nil
+	}
+}
+
+// Get the cloud mask as the result of a GranuleRequest
+func (s *Server) getCloudResult(path string) GranuleResult {
+	mask, err := s.getCloudMask(path)
+
+	return GranuleResult{mask: mask, err: err}
+}
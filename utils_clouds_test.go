@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+// Zone of the test masks, the rectangles below lie around 45° north and 15° east
+var testMaskZone = UTMZone{Zone: 33, North: true}
+
+// Bounds of a granule holding all rectangles of the tests
+var testMaskBounds = Bounds{South: 44, West: 14, North: 46, East: 16}
+
+// Get a GML position list of a rectangle given in UTM coordinates
+func utmRectangle(x0, y0, x1, y1 float64) string {
+	return fmt.Sprintf("%f %f %f %f %f %f %f %f %f %f", x0, y0, x1, y0, x1, y1, x0, y1, x0, y0)
+}
+
+// Get the AOI of a rectangle given in UTM coordinates
+// The covering is much finer than the AOI of a search, so that its area is within a permille of the rectangle.
+func utmRectangleAOI(t *testing.T, x0, y0, x1, y1 float64) s2.CellUnion {
+	t.Helper()
+
+	points, err := gmlPosList{Values: utmRectangle(x0, y0, x1, y1)}.points(testMaskZone)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring, err := normalizeRing(points)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return getCovering(polygonFromPoints(ring), CoveringOptions{MaxLevel: 20, MaxCells: 20000})
+}
+
+// Build a cloud mask of one polygon, with an optional hole
+func testCloudMask(exterior string, interior string) string {
+	hole := ""
+
+	if interior != "" {
+		hole = `<gml:interior><gml:LinearRing><gml:posList srsDimension="2">` + interior + `</gml:posList></gml:LinearRing></gml:interior>`
+	}
+
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<eop:Mask xmlns:eop="http://www.opengis.net/eop/2.0" xmlns:gml="http://www.opengis.net/gml/3.2">
+	<gml:boundedBy><gml:Envelope srsName="urn:ogc:def:crs:EPSG:8.8.1:32633"></gml:Envelope></gml:boundedBy>
+	<eop:maskMembers>
+		<eop:MaskFeature>
+			<eop:maskType codeSpace="urn:gs2:S2PDGS:maskType">OPAQUE</eop:maskType>
+			<eop:extentOf>
+				<gml:Polygon>
+					<gml:exterior><gml:LinearRing><gml:posList srsDimension="2">` + exterior + `</gml:posList></gml:LinearRing></gml:exterior>
+					` + hole + `
+				</gml:Polygon>
+			</eop:extentOf>
+		</eop:MaskFeature>
+	</eop:maskMembers>
+</eop:Mask>`
+}
+
+func TestParseCloudMask(t *testing.T) {
+	mask, err := parseCloudMask([]byte(testCloudMask(utmRectangle(500000, 5000000, 510000, 5010000), "")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mask.Clouds) != 1 || mask.Clouds[0].Type != "OPAQUE" {
+		t.Fatalf("parsed as %+v", mask.Clouds)
+	}
+
+	// 10 km by 10 km, with a scale factor of 0.9996 on the central meridian
+	area := mask.Clouds[0].Polygon.Area() * EarthRadius * EarthRadius / 1e6
+
+	if math.Abs(area-100) > 1 {
+		t.Errorf("cloud of %.2f km², expected about 100 km²", area)
+	}
+
+	center := s2.PointFromLatLng(s2.LatLngFromDegrees(testMaskZone.inverse(505000, 5005000)))
+
+	if !mask.Clouds[0].Polygon.ContainsPoint(center) {
+		t.Error("the cloud does not contain its center")
+	}
+}
+
+func TestCloudMaskAOIFraction(t *testing.T) {
+	square := utmRectangle(500000, 5000000, 510000, 5010000)
+	hole := utmRectangle(502500, 5002500, 507500, 5007500)
+
+	tests := []struct {
+		name     string
+		exterior string
+		interior string
+		// AOI in UTM coordinates
+		aoi      [4]float64
+		fraction float64
+	}{
+		{name: "AOI within the cloud", exterior: square, aoi: [4]float64{502000, 5002000, 508000, 5008000}, fraction: 1},
+		{name: "AOI twice the cloud", exterior: square, aoi: [4]float64{500000, 5000000, 520000, 5010000}, fraction: 0.5},
+		{name: "AOI next to the cloud", exterior: square, aoi: [4]float64{511000, 5000000, 520000, 5010000}, fraction: 0},
+		{name: "cloud with a hole", exterior: square, interior: hole, aoi: [4]float64{500000, 5000000, 510000, 5010000}, fraction: 0.75},
+	}
+
+	for _, test := range tests {
+		mask, err := parseCloudMask([]byte(testCloudMask(test.exterior, test.interior)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		aoi := utmRectangleAOI(t, test.aoi[0], test.aoi[1], test.aoi[2], test.aoi[3])
+
+		fraction, ok := mask.aoiFraction(aoi, testMaskBounds)
+
+		if !ok || math.Abs(fraction-test.fraction) > 0.002 {
+			t.Errorf("%s: fraction %.4f (%t), expected %.2f", test.name, fraction, ok, test.fraction)
+		}
+	}
+}
+
+func TestAOIFraction(t *testing.T) {
+	aoi := boundsAOI([]Bounds{{South: 0, West: 0, North: 1, East: 1}})
+
+	west := polygonFromPoints([]Point{{lat: -1, lng: -1}, {lat: -1, lng: 0.5}, {lat: 2, lng: 0.5}, {lat: 2, lng: -1}})
+	east := polygonFromPoints([]Point{{lat: -1, lng: 0.75}, {lat: -1, lng: 2}, {lat: 2, lng: 2}, {lat: 2, lng: 0.75}})
+
+	tests := []struct {
+		name     string
+		bounds   Bounds
+		polygons []*s2.Polygon
+		fraction float64
+		ok       bool
+	}{
+		{name: "without polygons", bounds: Bounds{South: -1, West: -1, North: 2, East: 2}, fraction: 0, ok: true},
+		{name: "western half", bounds: Bounds{South: -1, West: -1, North: 2, East: 2}, polygons: []*s2.Polygon{west}, fraction: 0.5, ok: true},
+		{name: "both sides", bounds: Bounds{South: -1, West: -1, North: 2, East: 2}, polygons: []*s2.Polygon{west, east}, fraction: 0.75, ok: true},
+		// Only the part of the AOI within the granule counts
+		{name: "granule of the western half", bounds: Bounds{South: -1, West: -1, North: 2, East: 0.5}, polygons: []*s2.Polygon{west}, fraction: 1, ok: true},
+		{name: "granule of the eastern half", bounds: Bounds{South: -1, West: 0.5, North: 2, East: 2}, polygons: []*s2.Polygon{west, east}, fraction: 0.5, ok: true},
+		{name: "granule elsewhere", bounds: Bounds{South: 10, West: 10, North: 11, East: 11}, polygons: []*s2.Polygon{west}},
+	}
+
+	for _, test := range tests {
+		fraction, ok := aoiFraction(aoi, test.bounds, test.polygons)
+
+		// The covering of the AOI reaches a little beyond the square
+		if ok != test.ok || math.Abs(fraction-test.fraction) > 0.01 {
+			t.Errorf("%s: fraction %.4f (%t), expected %.2f (%t)", test.name, fraction, ok, test.fraction, test.ok)
+		}
+	}
+}
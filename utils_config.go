@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config holds all settings of the service
+// Settings are read from a JSON config file, then environment variables, then command line flags,
+// each source overriding the previous one. Secrets are never accepted as flags since those are visible to other processes.
+type Config struct {
+	ProjectID     string `json:"project_id"`
+	Bucket        string `json:"bucket"`
+	Listen        string `json:"listen"`
+	GeoFabricHost string `json:"geofabrik_host"`
+	MapsAPIKey    string `json:"maps_api_key"`
+	Geocoder      string `json:"geocoder"`
+	GeoNamesPath  string `json:"geonames_path"`
+	GeocodeCache  string `json:"geocode_cache"`
+	// Size of the memory tier of the metadata cache in megabytes, a number or a numeric string in the config file
+	MetadataCacheSize json.Number `json:"metadata_cache_size"`
+	MetadataCacheDir  string      `json:"metadata_cache_dir"`
+	// Token required as a bearer token by the admin endpoints, which are disabled without it
+	AdminToken string `json:"admin_token"`
+
+	metadataCacheBytes int64
+}
+
+// DefaultConfig holds the settings used unless configured otherwise
+var DefaultConfig = Config{
+	Bucket:        "gcp-public-data-sentinel-2",
+	Listen:        "127.0.0.1:8888",
+	GeoFabricHost: "http://download.geofabrik.de/",
+	Geocoder:      "google",
+	GeocodeCache:  "geocode-cache.jsonl",
+
+	MetadataCacheSize: "64",
+}
+
+// Setting which can be given as an environment variable and, unless it is a secret, as a flag
+type configSetting struct {
+	value *string
+	env   string
+	flag  string
+	usage string
+}
+
+// Get all settings of a configuration
+func (c *Config) settings() []configSetting {
+	return []configSetting{
+		{&c.ProjectID, "SENTINEL_PROJECT_ID", "project", "Google Cloud project used for BigQuery"},
+		{&c.Bucket, "SENTINEL_BUCKET", "bucket", "Google Cloud Storage bucket holding the Sentinel-2 data"},
+		{&c.Listen, "SENTINEL_LISTEN", "listen", "address the server listens on"},
+		{&c.GeoFabricHost, "SENTINEL_GEOFABRIK_HOST", "geofabrik", "endpoint for fetching country polygons"},
+		{&c.MapsAPIKey, "GOOGLE_MAPS_API_KEY", "", ""},
+		{&c.Geocoder, "SENTINEL_GEOCODER", "geocoder", "geocoder used to resolve addresses (google or geonames)"},
+		{&c.GeoNamesPath, "SENTINEL_GEONAMES", "geonames", "path of the GeoNames cities dump file (e.g. cities15000.txt) used by the geonames geocoder"},
+		{&c.GeocodeCache, "SENTINEL_GEOCODE_CACHE", "geocode-cache", "file persisting geocoding results, empty to keep them in memory only"},
+		{(*string)(&c.MetadataCacheSize), "SENTINEL_METADATA_CACHE_SIZE", "metadata-cache-size", "megabytes of parsed metadata kept in memory"},
+		{&c.MetadataCacheDir, "SENTINEL_METADATA_CACHE_DIR", "metadata-cache-dir", "directory persisting parsed metadata, empty to keep it in memory only"},
+		{&c.AdminToken, "SENTINEL_ADMIN_TOKEN", "", ""},
+	}
+}
+
+// Load the configuration from a config file, the environment and command line arguments
+// The config file is given by the -config flag or the SENTINEL_CONFIG environment variable.
+func loadConfig(args []string) (Config, error) {
+	config := DefaultConfig
+
+	fs := flag.NewFlagSet("sentinel", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("SENTINEL_CONFIG"), "path of a JSON config file")
+
+	// Flags are parsed into a separate configuration so that only explicitly given flags take precedence
+	var flags Config
+	for _, s := range flags.settings() {
+		if s.flag != "" {
+			fs.StringVar(s.value, s.flag, "", s.usage)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return config, err
+	}
+
+	if *configPath != "" {
+		data, err := ioutil.ReadFile(*configPath)
+		if err != nil {
+			return config, err
+		}
+
+		if err := json.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("%s: %s", *configPath, err.Error())
+		}
+	}
+
+	settings, flagSettings := config.settings(), flags.settings()
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			*s.value = value
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for i, s := range flagSettings {
+			if s.flag == f.Name {
+				*settings[i].value = *s.value
+			}
+		}
+	})
+
+	return config, config.validate()
+}
+
+// Check that the configuration is complete and consistent
+func (c *Config) validate() error {
+	if c.ProjectID == "" {
+		return errors.New("config: a Google Cloud project is required (-project or SENTINEL_PROJECT_ID)")
+	}
+
+	if c.Bucket == "" {
+		return errors.New("config: a storage bucket is required")
+	}
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("config: invalid listen address '%s'", c.Listen)
+	}
+
+	host, err := url.Parse(c.GeoFabricHost)
+	if err != nil || host.Scheme == "" || host.Host == "" {
+		return fmt.Errorf("config: invalid geofabrik host '%s'", c.GeoFabricHost)
+	}
+
+	// Country names are appended to the host
+	if !strings.HasSuffix(c.GeoFabricHost, "/") {
+		c.GeoFabricHost += "/"
+	}
+
+	size, err := strconv.ParseInt(string(c.MetadataCacheSize), 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("config: invalid metadata cache size '%s'", c.MetadataCacheSize)
+	}
+
+	c.metadataCacheBytes = size << 20
+
+	switch c.Geocoder {
+	case "google":
+		if c.MapsAPIKey == "" {
+			return errors.New("config: the google geocoder requires an API key (GOOGLE_MAPS_API_KEY)")
+		}
+	case "geonames":
+		if c.GeoNamesPath == "" {
+			return errors.New("config: the geonames geocoder requires a GeoNames dump file (-geonames)")
+		}
+	default:
+		return fmt.Errorf("config: unknown geocoder '%s'", c.Geocoder)
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// DefaultCorridorWidth is the width in meters of the corridor built around lines and points
+const DefaultCorridorWidth = 1000.0
+
+// MaxCorridorWidth is the upper bound on the width of a corridor in meters
+const MaxCorridorWidth = 100000.0
+
+// Maximum number of pieces a segment is split into for the AOI of a corridor
+const maxAOISegmentPieces = 16
+
+// Minimum distance in meters between consecutive vertices of a corridor
+// Closer vertices, such as the jitter of a GPS track at rest, would give segments without a direction.
+const minCorridorVertexDistance = 1.0
+
+// Parse the width of a corridor given in meters, falling back to the default
+func parseWidth(width string) (float64, error) {
+	if width == "" {
+		return DefaultCorridorWidth, nil
+	}
+
+	meters, err := strconv.ParseFloat(width, 64)
+
+	if err != nil || !(meters > 0 && meters <= MaxCorridorWidth) {
+		return 0, fmt.Errorf("Parameter 'width' must be a number of meters between 0 and %.0f", MaxCorridorWidth)
+	}
+
+	return meters, nil
+}
+
+// Parse a polyline given as "lat,lng|lat,lng|..."
+func parsePath(path string) ([]Point, error) {
+	vertices := strings.Split(path, "|")
+	points := make([]Point, 0, len(vertices))
+
+	for _, vertex := range vertices {
+		coords := strings.Split(vertex, ",")
+
+		if len(coords) != 2 {
+			return nil, fmt.Errorf("Path vertex '%s' must be given as 'lat,lng'", vertex)
+		}
+
+		lat, lng, err := parseLatLng(strings.TrimSpace(coords[0]), strings.TrimSpace(coords[1]))
+		if err != nil {
+			return nil, err
+		}
+
+		points = append(points, Point{lat: lat, lng: lng})
+	}
+
+	return points, nil
+}
+
+// Get the union of the coverings of all shapes
+// Polygons are normalized and simplified, lines and points are buffered into corridors of the given width.
+func getShapesCovering(shapes []Shape, width float64, opts CoveringOptions) (s2.CellUnion, error) {
+	coverings := make([]s2.CellUnion, 0, len(shapes))
+
+	for _, shape := range shapes {
+		if !shape.Closed {
+			cover, err := getCorridorCovering(shape.Points, width, opts)
+			if err != nil {
+				return nil, err
+			}
+			coverings = append(coverings, cover)
+			continue
+		}
+
+		ring, err := normalizeRing(shape.Points)
+		if err != nil {
+			return nil, err
+		}
+
+		coverings = append(coverings, getCovering(polygonFromPoints(simplifyRing(ring, opts)), opts))
+	}
+
+	return s2.CellUnionFromUnion(coverings...), nil
+}
+
+// Get the covering of a corridor of a given width in meters along a line given in degrees
+// The corridor is the union of a cap around every vertex and a quadrilateral along every segment.
+func getCorridorCovering(line []Point, width float64, opts CoveringOptions) (s2.CellUnion, error) {
+	points := make([]s2.Point, 0, len(line))
+
+	// A dropped vertex stays within the cap around the previous vertex
+	minDistance := math.Min(minCorridorVertexDistance, width/2)
+
+	for _, p := range line {
+		point := s2.PointFromLatLng(s2.LatLngFromDegrees(p.lat, p.lng))
+
+		if len(points) > 0 && points[len(points)-1].Distance(point).Radians()*EarthRadius < minDistance {
+			continue
+		}
+		points = append(points, point)
+	}
+
+	if len(points) == 0 {
+		return nil, errors.New("Line must have at least one vertex")
+	}
+
+	coverings := make([]s2.CellUnion, 0, 2*len(points))
+
+	for i, p := range points {
+		coverings = append(coverings, getCovering(capFromPoint(p, width/2), opts))
+
+		if i > 0 {
+			coverings = append(coverings, getCovering(segmentLoop(points[i-1], p, width/2), opts))
+		}
+	}
+
+	return s2.CellUnionFromUnion(coverings...), nil
+}
+
+// Get the AOI of a corridor of a given width in meters along a line given in degrees
+// Every covering is limited in cells, so the covering of a long and narrow segment reaches far beyond it.
+// Segments are split into pieces of at most ten times the width, keeping the AOI close to the corridor.
+func getCorridorAOI(line []Point, width float64) (s2.CellUnion, error) {
+	dense := make([]Point, 0, len(line))
+
+	for i, p := range line {
+		if i > 0 {
+			a := s2.PointFromLatLng(s2.LatLngFromDegrees(line[i-1].lat, line[i-1].lng))
+			b := s2.PointFromLatLng(s2.LatLngFromDegrees(p.lat, p.lng))
+
+			length := a.Distance(b).Radians() * EarthRadius
+			pieces := int(math.Min(math.Ceil(length/(10*width)), maxAOISegmentPieces))
+
+			for k := 1; k < pieces; k++ {
+				ll := s2.LatLngFromPoint(s2.Interpolate(float64(k)/float64(pieces), a, b))
+				dense = append(dense, Point{lat: ll.Lat.Degrees(), lng: ll.Lng.Degrees()})
+			}
+		}
+
+		dense = append(dense, p)
+	}
+
+	return getCorridorCovering(dense, width, AOICoveringOptions)
+}
+
+// Build a spherical cap of a given radius in meters around a point
+func capFromPoint(p s2.Point, radius float64) s2.Cap {
+	ll := s2.LatLngFromPoint(p)
+	return capFromRadius(ll.Lat.Degrees(), ll.Lng.Degrees(), radius)
+}
+
+// Build a quadrilateral loop extending a given distance in meters to both sides of the segment ab
+func segmentLoop(a s2.Point, b s2.Point, distance float64) *s2.Loop {
+	angle := distance / EarthRadius
+
+	// Normal of the great circle through a and b, pointing to the left of the direction of travel
+	// The robust cross product keeps the normal accurate for short segments.
+	n := a.PointCross(b).Normalize()
+
+	offset := func(p s2.Point, side float64) s2.Point {
+		return s2.Point{Vector: p.Vector.Mul(math.Cos(angle)).Add(n.Mul(side * math.Sin(angle))).Normalize()}
+	}
+
+	loop := s2.LoopFromPoints([]s2.Point{offset(a, -1), offset(b, -1), offset(b, 1), offset(a, 1)})
+
+	// The loop has to enclose the segment rather than the rest of the globe
+	if loop.Area() > 2*math.Pi {
+		loop.Invert()
+	}
+
+	return loop
+}
+
+// Order granules along a line given in degrees
+// Each granule is placed at the distance along the line of the point closest to the center of the granule.
+func orderAlongLine(granules []Granule, line []Point) {
+	points := make([]s2.Point, 0, len(line))
+
+	for _, p := range line {
+		points = append(points, s2.PointFromLatLng(s2.LatLngFromDegrees(p.lat, p.lng)))
+	}
+
+	positions := make(map[string]s1.Angle, len(granules))
+
+	for _, g := range granules {
+		center := s2.PointFromLatLng(s2.LatLngFromDegrees(g.Bounds.center()))
+
+		position, best := s1.Angle(0), s1.Angle(math.Inf(1))
+		along := s1.Angle(0)
+
+		for i := 0; i < len(points); i++ {
+			if i == 0 {
+				if d := center.Distance(points[0]); d < best {
+					position, best = 0, d
+				}
+				continue
+			}
+
+			a, b := points[i-1], points[i]
+
+			if d := s2.DistanceFromSegment(center, a, b); d < best {
+				position, best = along+a.Distance(s2.Project(center, a, b)), d
+			}
+
+			along += a.Distance(b)
+		}
+
+		positions[g.GranuleID] = position
+	}
+
+	sort.SliceStable(granules, func(i, j int) bool {
+		return positions[granules[i].GranuleID] < positions[granules[j].GranuleID]
+	})
+}
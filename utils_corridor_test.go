@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+// Get the area of a covering in square kilometers
+func coveringArea(cover s2.CellUnion) float64 {
+	return cover.ExactArea() * EarthRadius * EarthRadius / 1e6
+}
+
+func TestSegmentLoop(t *testing.T) {
+	a := s2.PointFromLatLng(s2.LatLngFromDegrees(50, 10))
+	b := s2.PointFromLatLng(s2.LatLngFromDegrees(50, 11))
+
+	loop := segmentLoop(a, b, 500)
+
+	// About 71.6 km long and 1 km wide
+	length := a.Distance(b).Radians() * EarthRadius / 1000
+
+	if area := loop.Area() * EarthRadius * EarthRadius / 1e6; math.Abs(area-length) > 0.01*length {
+		t.Errorf("area %.2f km², expected %.2f km²", area, length)
+	}
+
+	if !loop.ContainsPoint(s2.Interpolate(0.5, a, b)) {
+		t.Error("the loop does not contain the middle of the segment")
+	}
+
+	if loop.ContainsPoint(s2.PointFromLatLng(s2.LatLngFromDegrees(50.02, 10.5))) {
+		t.Error("the loop contains a point 2 km off the segment")
+	}
+}
+
+func TestSegmentLoopValid(t *testing.T) {
+	a := s2.PointFromLatLng(s2.LatLngFromDegrees(50, 10))
+
+	// From about 100 km down to below a millimeter
+	for _, d := range []float64{1, 1e-2, 1e-4, 1e-6, 1e-8} {
+		b := s2.PointFromLatLng(s2.LatLngFromDegrees(50+d, 10+d))
+
+		if err := segmentLoop(a, b, 500).Validate(); err != nil {
+			t.Errorf("loop of a segment of %g degrees: %s", d, err.Error())
+		}
+	}
+}
+
+func TestGetCorridorCovering(t *testing.T) {
+	opts := DefaultCoveringOptions
+
+	tests := []struct {
+		name string
+		line []Point
+		// Upper bound of the area of the covering in square kilometers
+		area float64
+	}{
+		{name: "single point", line: []Point{{lat: 50, lng: 10}}, area: 5},
+		{name: "segment", line: []Point{{lat: 50, lng: 10}, {lat: 50, lng: 10.1}}, area: 20},
+		{name: "duplicate points", line: []Point{{lat: 50, lng: 10}, {lat: 50, lng: 10}, {lat: 50, lng: 10.1}, {lat: 50, lng: 10.1}}, area: 20},
+		{
+			name: "near-duplicate points",
+			line: []Point{{lat: 50, lng: 10}, {lat: 50, lng: 10 + 1e-12}, {lat: 50 + 1e-12, lng: 10}, {lat: 50, lng: 10.1}, {lat: 50 + 1e-13, lng: 10.1}},
+			area: 20,
+		},
+		{name: "resting track", line: []Point{{lat: 50, lng: 10}, {lat: 50 + 1e-14, lng: 10 + 1e-14}, {lat: 50 - 1e-14, lng: 10}}, area: 5},
+		{name: "across the antimeridian", line: []Point{{lat: 0, lng: 179.95}, {lat: 0, lng: -179.95}}, area: 20},
+	}
+
+	for _, test := range tests {
+		cover, err := getCorridorCovering(test.line, 1000, opts)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+
+		if area := coveringArea(cover); area > test.area {
+			t.Errorf("%s: covering of %.1f km², expected at most %.0f km²", test.name, area, test.area)
+		}
+
+		for _, p := range test.line {
+			if !cover.ContainsPoint(s2.PointFromLatLng(s2.LatLngFromDegrees(p.lat, p.lng))) {
+				t.Errorf("%s: covering does not contain %+v", test.name, p)
+			}
+		}
+	}
+
+	if _, err := getCorridorCovering(nil, 1000, opts); err == nil {
+		t.Error("a line without vertices was accepted")
+	}
+}
+
+func TestGetCorridorAOI(t *testing.T) {
+	line := []Point{{lat: 50, lng: 10}, {lat: 50, lng: 11}}
+
+	aoi, err := getCorridorAOI(line, 1000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The corridor itself is about 72 km²
+	if area := coveringArea(aoi); area < 70 || area > 90 {
+		t.Errorf("AOI of %.1f km²", area)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path   string
+		points int
+		ok     bool
+	}{
+		{path: "50,10", points: 1, ok: true},
+		{path: "50,10|51, 11|52,12", points: 3, ok: true},
+		{path: "50,10|", ok: false},
+		{path: "50;10", ok: false},
+		{path: "95,10", ok: false},
+		{path: "50,10,0", ok: false},
+	}
+
+	for _, test := range tests {
+		points, err := parsePath(test.path)
+
+		if (err == nil) != test.ok || len(points) != test.points {
+			t.Errorf("%s: %d points, error %v", test.path, len(points), err)
+		}
+	}
+}
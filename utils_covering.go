@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// MaxCoveringCells is the upper bound on the number of cells a client may request for a covering
+const MaxCoveringCells = 500
+
+// MaxCoveringMinLevel is the upper bound on the minimum level a client may request for a covering
+// The coverer ignores the maximum number of cells when the minimum level forces finer cells,
+// level 10 cells are about 80 km² large, so even a large country is covered by a few hundred thousand cells.
+const MaxCoveringMinLevel = 10
+
+// EarthRadius is the mean radius of the Earth in meters
+const EarthRadius = 6371010.0
+
+// MaxSearchRadius is the upper bound on the radius of a search around a point in meters
+const MaxSearchRadius = 500000.0
+
+// CoveringOptions holds the parameters of the S2 region coverer
+type CoveringOptions struct {
+	MinLevel int
+	MaxLevel int
+	MaxCells int
+}
+
+// DefaultCoveringOptions are used for every parameter not given by the client
+var DefaultCoveringOptions = CoveringOptions{MinLevel: 0, MaxLevel: s2.MaxLevel, MaxCells: 40}
+
+// Parse the covering parameters from the request, falling back to the defaults
+func parseCoveringOptions(r *http.Request) (CoveringOptions, error) {
+	opts := DefaultCoveringOptions
+
+	params := []struct {
+		name  string
+		value *int
+		min   int
+		max   int
+	}{
+		{"min_level", &opts.MinLevel, 0, MaxCoveringMinLevel},
+		{"max_level", &opts.MaxLevel, 0, s2.MaxLevel},
+		{"max_cells", &opts.MaxCells, 1, MaxCoveringCells},
+	}
+
+	for _, p := range params {
+		value := r.FormValue(p.name)
+
+		if value == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+
+		if err != nil {
+			return opts, fmt.Errorf("Parameter '%s' must be an integer", p.name)
+		}
+
+		if n < p.min || n > p.max {
+			return opts, fmt.Errorf("Parameter '%s' must be between %d and %d", p.name, p.min, p.max)
+		}
+
+		*p.value = n
+	}
+
+	if opts.MinLevel > opts.MaxLevel {
+		return opts, fmt.Errorf("Parameter 'min_level' must not be greater than 'max_level'")
+	}
+
+	return opts, nil
+}
+
+// Get the covering of a region using the specified covering parameters
+func getCovering(region s2.Region, opts CoveringOptions) s2.CellUnion {
+	rc := &s2.RegionCoverer{MinLevel: opts.MinLevel, MaxLevel: opts.MaxLevel, MaxCells: opts.MaxCells}
+	return rc.Covering(region)
+}
+
+// Get the covering of a region using covering parameters given by a client
+// Every cell becomes a bound of the index query, so coverings with more than MaxCoveringCells cells are rejected.
+func getClientCovering(region s2.Region, opts CoveringOptions) (s2.CellUnion, error) {
+	cover := getCovering(region, opts)
+
+	if len(cover) > MaxCoveringCells {
+		return nil, fmt.Errorf("The covering has %d cells, more than %d, use a lower 'min_level'", len(cover), MaxCoveringCells)
+	}
+
+	return cover, nil
+}
+
+// AOICoveringOptions are used for the coverings from which the fractions of an AOI are computed
+// Level 20 cells are about 10 m wide, finer than the 60 m raster of the cloud masks.
+var AOICoveringOptions = CoveringOptions{MinLevel: 0, MaxLevel: 20, MaxCells: MaxCoveringCells}
+
+// aoiFractionCells is about the number of cells of the finest level into which an AOI is divided to measure its fractions
+// Only the cells along the boundaries are divided, the measured fractions are accurate to about a thousandth.
+const aoiFractionCells = 1e6
+
+// cellRegion decides for a cell whether a region contains or intersects it
+type cellRegion struct {
+	contains   func(s2.Cell) bool
+	intersects func(s2.Cell) bool
+}
+
+// Get the area of the part of a cell within a region
+// Cells on the boundary of the region are divided down to the given level, where half of their area is counted.
+func (r cellRegion) area(cell s2.Cell, level int) float64 {
+	if !r.intersects(cell) {
+		return 0
+	}
+
+	if r.contains(cell) {
+		return cell.ExactArea()
+	}
+
+	children, ok := cell.Children()
+
+	if !ok || cell.Level() >= level {
+		return cell.ExactArea() / 2
+	}
+
+	area := 0.0
+
+	for _, child := range children {
+		area += r.area(child, level)
+	}
+
+	return area
+}
+
+// Get the fraction of an AOI within bounds which is covered by any of the polygons
+// The areas are measured by dividing the cells of the AOI along the boundaries of the bounds and polygons,
+// the second return value is false when the AOI does not overlap the bounds.
+func aoiFraction(aoi s2.CellUnion, bounds Bounds, polygons []*s2.Polygon) (float64, bool) {
+	rect := bounds.rect()
+	level := s2.AvgAreaMetric.ClosestLevel(aoi.ExactArea() / aoiFractionCells)
+
+	within := cellRegion{contains: rect.ContainsCell, intersects: rect.IntersectsCell}
+	area := 0.0
+
+	for _, id := range aoi {
+		area += within.area(s2.CellFromCellID(id), level)
+	}
+
+	if area == 0 {
+		return 0, false
+	}
+
+	candidates := make([]*s2.Polygon, 0)
+
+	for _, polygon := range polygons {
+		// Most polygons are far away from a small AOI
+		if rect.Intersects(polygon.RectBound()) {
+			candidates = append(candidates, polygon)
+		}
+	}
+
+	covered := cellRegion{
+		contains: func(cell s2.Cell) bool {
+			if !rect.ContainsCell(cell) {
+				return false
+			}
+
+			for _, polygon := range candidates {
+				if polygon.ContainsCell(cell) {
+					return true
+				}
+			}
+
+			return false
+		},
+		intersects: func(cell s2.Cell) bool {
+			if !rect.IntersectsCell(cell) {
+				return false
+			}
+
+			bound := cell.RectBound()
+
+			for _, polygon := range candidates {
+				if bound.Intersects(polygon.RectBound()) && polygon.IntersectsCell(cell) {
+					return true
+				}
+			}
+
+			return false
+		},
+	}
+
+	coveredArea := 0.0
+
+	for _, id := range aoi {
+		coveredArea += covered.area(s2.CellFromCellID(id), level)
+	}
+
+	return math.Min(coveredArea/area, 1), true
+}
+
+// Get the bounds of all cells of a covering
+func coveringBounds(cover s2.CellUnion) []Bounds {
+	bounds := make([]Bounds, 0, len(cover))
+
+	for i := 0; i < len(cover); i++ {
+		bounds = append(bounds, rectBounds(s2.CellFromCellID(cover[i]).RectBound())...)
+	}
+
+	return bounds
+}
+
+// Parse a search radius given in meters
+func parseRadius(radius string) (float64, error) {
+	meters, err := strconv.ParseFloat(radius, 64)
+
+	if err != nil || !(meters > 0 && meters <= MaxSearchRadius) {
+		return 0, fmt.Errorf("Parameter 'radius' must be a number of meters between 0 and %.0f", MaxSearchRadius)
+	}
+
+	return meters, nil
+}
+
+// Build a spherical cap of a given radius in meters around a point given in degrees
+func capFromRadius(lat float64, lng float64, radius float64) s2.Cap {
+	center := s2.PointFromLatLng(s2.LatLngFromDegrees(lat, lng))
+	return s2.CapFromCenterAngle(center, s1.Angle(radius/EarthRadius))
+}
+
+// Build a polygon from a ring of points given in degrees
+// The ring is expected to be normalized by normalizeRing
+func polygonFromPoints(degreePoints []Point) *s2.Polygon {
+	points := make([]s2.Point, 0, len(degreePoints))
+
+	for _, p := range degreePoints {
+		points = append(points, s2.PointFromLatLng(s2.LatLngFromDegrees(p.lat, p.lng)))
+	}
+
+	l1 := s2.LoopFromPoints(points)
+	loops := []*s2.Loop{l1}
+
+	return s2.PolygonFromLoops(loops)
+}
+
+// Build a polygon from rings of points given in degrees
+// The rings are expected to be normalized, rings nested within other rings are holes.
+func polygonFromRings(rings [][]Point) *s2.Polygon {
+	loops := make([]*s2.Loop, 0, len(rings))
+
+	for _, ring := range rings {
+		loops = append(loops, polygonFromPoints(ring).Loop(0))
+	}
+
+	return s2.PolygonFromLoops(loops)
+}
+
+// Convert the cells of a covering to a GeoJSON feature collection
+func coveringToGeoJSON(cover s2.CellUnion) GeoJSONFeatureCollection {
+	features := make([]GeoJSONFeature, 0, len(cover))
+
+	for _, id := range cover {
+		cell := s2.CellFromCellID(id)
+
+		ring := make([][2]float64, 0, 5)
+
+		for k := 0; k < 4; k++ {
+			ll := s2.LatLngFromPoint(cell.Vertex(k))
+			ring = append(ring, [2]float64{ll.Lng.Degrees(), ll.Lat.Degrees()})
+		}
+
+		// GeoJSON rings are closed explicitly
+		ring = append(ring, ring[0])
+
+		features = append(features, GeoJSONFeature{
+			Type:     "Feature",
+			Geometry: GeoJSONGeometry{Type: "Polygon", Coordinates: [][][2]float64{ring}},
+			Properties: map[string]interface{}{
+				"id":    id.ToToken(),
+				"level": id.Level(),
+			},
+		})
+	}
+
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+}
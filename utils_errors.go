@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// ErrorKind classifies the errors returned by handlers
+type ErrorKind int
+
+const (
+	// KindInternal is used for all errors which are not classified otherwise
+	KindInternal ErrorKind = iota
+	// KindValidation is used for invalid or missing request parameters
+	KindValidation
+	// KindNotFound is used when a requested resource does not exist
+	KindNotFound
+	// KindUpstream is used when a service the request depends on fails
+	KindUpstream
+	// KindTimeout is used when a service the request depends on does not answer in time
+	KindTimeout
+	// KindUnauthorized is used when a request lacks valid credentials
+	KindUnauthorized
+)
+
+// Status code and error code of every kind of error
+var errorKinds = map[ErrorKind]struct {
+	status int
+	code   string
+}{
+	KindInternal:     {http.StatusInternalServerError, "internal_error"},
+	KindValidation:   {http.StatusBadRequest, "validation_error"},
+	KindNotFound:     {http.StatusNotFound, "not_found"},
+	KindUpstream:     {http.StatusBadGateway, "upstream_error"},
+	KindTimeout:      {http.StatusGatewayTimeout, "timeout"},
+	KindUnauthorized: {http.StatusUnauthorized, "unauthorized"},
+}
+
+// APIError is an error returned by a handler, it is rendered as a JSON error envelope
+type APIError struct {
+	Kind    ErrorKind
+	Message string
+	Details interface{}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Error envelope sent to clients
+type errorEnvelope struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Create an error for invalid request parameters
+func validationError(err error) *APIError {
+	return &APIError{Kind: KindValidation, Message: err.Error()}
+}
+
+// Create an error for a resource which does not exist
+func notFoundError(err error) *APIError {
+	return &APIError{Kind: KindNotFound, Message: err.Error()}
+}
+
+// Create an error for a failing upstream service, timeouts are classified as such
+func upstreamError(err error) *APIError {
+	if isTimeout(err) {
+		return &APIError{Kind: KindTimeout, Message: err.Error()}
+	}
+
+	return &APIError{Kind: KindUpstream, Message: err.Error()}
+}
+
+// Create an error for a failing download, a missing file is classified as not found
+func downloadError(err error) *APIError {
+	if statusErr, ok := err.(*HTTPStatusError); ok && statusErr.StatusCode == http.StatusNotFound {
+		return notFoundError(err)
+	}
+
+	return upstreamError(err)
+}
+
+// ErrMissingParameters is returned when a request does not have all required parameters
+var ErrMissingParameters = &APIError{Kind: KindValidation, Message: "Not enough parameters to complete the request"}
+
+// ErrUnauthorized is returned when a request to an admin endpoint does not have the admin token
+var ErrUnauthorized = &APIError{Kind: KindUnauthorized, Message: "A valid admin token is required"}
+
+// Check whether an error is caused by a timeout
+func isTimeout(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+
+	netErr, ok := err.(net.Error)
+
+	return ok && netErr.Timeout()
+}
+
+// APIHandler is a handler returning an error, which is rendered as a JSON error envelope
+type APIHandler func(w http.ResponseWriter, r *http.Request) error
+
+func (h APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := h(w, r); err != nil {
+		writeError(w, r, err)
+	}
+}
+
+// Write an error as a JSON error envelope
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr, ok := err.(*APIError)
+
+	if !ok {
+		kind := KindInternal
+
+		if isTimeout(err) {
+			kind = KindTimeout
+		}
+
+		apiErr = &APIError{Kind: kind, Message: err.Error()}
+	}
+
+	kind := errorKinds[apiErr.Kind]
+
+	if kind.status >= http.StatusInternalServerError {
+		log.Printf("%s %s: %s", r.Method, r.URL.Path, apiErr.Message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(kind.status)
+
+	json.NewEncoder(w).Encode(errorEnvelope{Code: kind.code, Message: apiErr.Message, Details: apiErr.Details})
+}
+
+// Write a value as JSON
+// The value is encoded completely before anything is written, so that an encoding error can still be reported.
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	// Do not escape ampersands ('\0026')
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+// Recover from panics in a handler, logging them and answering with an internal error
+// A single failing request must never take down the whole process.
+func recoverHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("panic: %v\n%s", p, debug.Stack())
+				writeError(w, r, &APIError{Kind: KindInternal, Message: "Internal server error"})
+			}
+		}()
+
+		h.ServeHTTP(w, r)
+	})
+}
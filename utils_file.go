@@ -8,13 +8,31 @@ import "strings"
 
 import "strconv"
 
+import "errors"
+import "fmt"
+import "log"
+
 // Point holds latitude, longitude coordinates in degrees
 type Point struct {
 	lat float64
 	lng float64
 }
 
+// MaxDownloadAttempts is the number of times a download failing verification is attempted
+const MaxDownloadAttempts = 3
+
+// HTTPStatusError is returned when a download is answered with a status other than 200 OK
+// It does not hold the URL, which may contain credentials.
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("download failed with status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
 // Download a file from a specified path
+// Failing status codes and bodies shorter than the announced Content-Length are returned as errors.
 func downloadFile(path string) ([]byte, error) {
 	r, err := http.Get(path)
 
@@ -24,40 +42,130 @@ func downloadFile(path string) ([]byte, error) {
 
 	defer r.Body.Close()
 
+	if r.StatusCode != http.StatusOK {
+		return nil, &HTTPStatusError{StatusCode: r.StatusCode}
+	}
+
 	data, err := ioutil.ReadAll(r.Body)
 
-	return data, err
+	if err != nil {
+		return nil, err
+	}
+
+	if r.ContentLength >= 0 && int64(len(data)) != r.ContentLength {
+		return nil, fmt.Errorf("download truncated after %d of %d bytes", len(data), r.ContentLength)
+	}
+
+	return data, nil
+}
+
+// Download a file and verify it against its checksum, retrying corrupted or truncated transfers
+// Client errors are not retried, since another attempt would fail the same way.
+func downloadVerified(path string, checksum FileChecksum) ([]byte, error) {
+	var err error
+
+	for attempt := 1; attempt <= MaxDownloadAttempts; attempt++ {
+		var data []byte
+
+		data, err = downloadFile(path)
+
+		if err == nil {
+			err = checksum.verify(data)
+		}
+
+		if err == nil {
+			return data, nil
+		}
+
+		if statusErr, ok := err.(*HTTPStatusError); ok && statusErr.StatusCode < http.StatusInternalServerError {
+			return nil, err
+		}
+
+		log.Printf("Download attempt %d of %d failed: %s", attempt, MaxDownloadAttempts, err.Error())
+	}
+
+	return nil, err
 }
 
-// Parse .poly file line by line from a byte array
-func parsePolyData(data []byte) ([]Point, error) {
+// Parse the rings of a .poly file line by line from a byte array
+// The file starts with a name followed by sections of coordinates, each ending with END, and a final END.
+// Every section is a ring of its own, subtracted sections (with a header starting with '!') are holes.
+func parsePolyData(data []byte) ([][]Point, error) {
 	scanner := bufio.NewScanner(strings.NewReader(string(data)))
 	scanner.Split(bufio.ScanLines)
 
-	var line string
+	rings := make([][]Point, 0)
 
-	points := make([]Point, 0)
+	// The ring of the current section, nil outside of sections
+	var ring []Point
+
+	named := false
+	ended := false
+	number := 0
 
 	for scanner.Scan() {
-		line = strings.TrimSpace(scanner.Text())
+		number++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+
+		if ended {
+			return nil, fmt.Errorf("Invalid .poly data after the final END on line %d", number)
+		}
+
+		if !named {
+			named = true
+			continue
+		}
+
+		if ring == nil {
+			// Either the header of the next section or the end of the file
+			if line == "END" {
+				ended = true
+			} else {
+				ring = make([]Point, 0)
+			}
+			continue
+		}
 
-		if line == "none" || line == "END" || line == "1" {
+		if line == "END" {
+			rings = append(rings, ring)
+			ring = nil
 			continue
 		}
 
-		arr := strings.Split(line, "   ")
+		fields := strings.Fields(line)
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid .poly coordinates on line %d", number)
+		}
 
-		lat, err := strconv.ParseFloat(arr[1], 32)
+		lng, err := strconv.ParseFloat(fields[0], 64)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Invalid .poly longitude on line %d", number)
 		}
-		lng, err := strconv.ParseFloat(arr[0], 32)
+
+		lat, err := strconv.ParseFloat(fields[1], 64)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Invalid .poly latitude on line %d", number)
 		}
 
-		points = append(points, Point{lat: lat, lng: lng})
+		ring = append(ring, Point{lat: lat, lng: lng})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if ring != nil || !ended {
+		return nil, errors.New("Invalid .poly data, missing END")
+	}
+
+	if len(rings) == 0 {
+		return nil, errors.New("Invalid .poly data without any section")
 	}
 
-	return points, nil
+	return rings, nil
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePolyData(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		rings []int
+	}{
+		{
+			name:  "single section",
+			data:  "austria\n1\n   1.0E+01   4.7E+01\n   1.1E+01   4.7E+01\n   1.1E+01   4.8E+01\nEND\nEND\n",
+			rings: []int{3},
+		},
+		{
+			name:  "section with a hole",
+			data:  "area\nouter\n 0 0\n 4 0\n 4 4\n 0 4\nEND\n!hole\n 1 1\n 2 1\n 2 2\nEND\nEND\n",
+			rings: []int{4, 3},
+		},
+		{
+			name:  "several sections, blank lines and CRLF",
+			data:  "islands\r\n\r\n1\r\n 0 0\r\n 1 0\r\n 1 1\r\nEND\r\n2\r\n 5 5\r\n 6 5\r\n 6 6\r\nEND\r\nEND\r\n\r\n",
+			rings: []int{3, 3},
+		},
+		{
+			name:  "tabs between coordinates",
+			data:  "name\n1\n0\t0\n1\t0\n1\t1\nEND\nEND",
+			rings: []int{3},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rings, err := parsePolyData([]byte(test.data))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rings) != len(test.rings) {
+				t.Fatalf("%d rings, expected %d", len(rings), len(test.rings))
+			}
+
+			for i, ring := range rings {
+				if len(ring) != test.rings[i] {
+					t.Errorf("ring %d has %d points, expected %d", i, len(ring), test.rings[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParsePolyDataCoordinates(t *testing.T) {
+	rings, err := parsePolyData([]byte("name\n1\n 16.5 48.25\n 17 48\n 16 47\nEND\nEND\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Longitude comes first in .poly files
+	if p := rings[0][0]; p.lng != 16.5 || p.lat != 48.25 {
+		t.Errorf("first point parsed as %+v", p)
+	}
+}
+
+func TestParsePolyDataRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		error string
+	}{
+		{name: "empty", data: "", error: "missing END"},
+		{name: "name only", data: "name\n", error: "missing END"},
+		{name: "missing section END", data: "name\n1\n 0 0\n 1 0\n 1 1\n", error: "missing END"},
+		{name: "missing final END", data: "name\n1\n 0 0\n 1 0\n 1 1\nEND\n", error: "missing END"},
+		{name: "data after the final END", data: "name\n1\n 0 0\n 1 0\n 1 1\nEND\nEND\n2\n", error: "after the final END on line 8"},
+		{name: "extra END", data: "name\n1\n 0 0\nEND\nEND\nEND\n", error: "after the final END on line 6"},
+		{name: "without sections", data: "name\nEND\n", error: "without any section"},
+		{name: "single coordinate", data: "name\n1\n 0\nEND\nEND\n", error: "coordinates on line 3"},
+		{name: "three coordinates", data: "name\n1\n 0 0 0\nEND\nEND\n", error: "coordinates on line 3"},
+		{name: "invalid longitude", data: "name\n1\n x 0\nEND\nEND\n", error: "longitude on line 3"},
+		{name: "invalid latitude", data: "name\n1\n 0 0\n 0 1,5\nEND\nEND\n", error: "latitude on line 4"},
+	}
+
+	for _, test := range tests {
+		_, err := parsePolyData([]byte(test.data))
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: error %v, expected %q", test.name, err, test.error)
+		}
+	}
+}
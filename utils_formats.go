@@ -0,0 +1,649 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Shape is a search area read from one of the supported input formats
+// A closed shape is the outer ring of a polygon (holes are not needed to cover it),
+// an open shape is a line or a single point which is buffered into a corridor.
+type Shape struct {
+	Points []Point
+	Closed bool
+}
+
+// Parse shapes from a request body, the format is selected by its Content-Type
+func parseShapes(contentType string, data []byte) ([]Shape, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Content-Type '%s'", contentType)
+	}
+
+	var shapes []Shape
+
+	switch mediaType {
+	case "application/geo+json", "application/vnd.geo+json", "application/json":
+		shapes, err = parseGeoJSON(data)
+	case "application/wkt", "text/wkt", "text/plain":
+		shapes, err = parseWKT(string(data))
+	case "application/vnd.google-earth.kml+xml", "application/kml+xml":
+		shapes, err = parseKML(data)
+	case "application/gpx+xml", "application/gpx":
+		shapes, err = parseGPX(data)
+	default:
+		return nil, fmt.Errorf("Unsupported Content-Type '%s'", mediaType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(shapes) == 0 {
+		return nil, errors.New("The request does not contain any geometry")
+	}
+
+	return shapes, nil
+}
+
+// GeoJSON object of any type, only the members required for reading geometries are decoded
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	ID          json.RawMessage `json:"id"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometries  []geoJSONObject `json:"geometries"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Features    []geoJSONObject `json:"features"`
+}
+
+// Parse shapes from a GeoJSON geometry, feature or feature collection
+func parseGeoJSON(data []byte) ([]Shape, error) {
+	var obj geoJSONObject
+
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+
+	return obj.shapes()
+}
+
+// Get all shapes of a GeoJSON object
+func (o geoJSONObject) shapes() ([]Shape, error) {
+	var shapes []Shape
+
+	switch o.Type {
+	case "FeatureCollection":
+		for _, f := range o.Features {
+			s, err := f.shapes()
+			if err != nil {
+				return nil, err
+			}
+			shapes = append(shapes, s...)
+		}
+		return shapes, nil
+	case "Feature":
+		if o.Geometry == nil {
+			return nil, nil
+		}
+		return o.Geometry.shapes()
+	case "GeometryCollection":
+		for _, g := range o.Geometries {
+			s, err := g.shapes()
+			if err != nil {
+				return nil, err
+			}
+			shapes = append(shapes, s...)
+		}
+		return shapes, nil
+	case "Point":
+		var position []float64
+		if err := json.Unmarshal(o.Coordinates, &position); err != nil {
+			return nil, err
+		}
+		p, err := geoJSONPoint(position)
+		if err != nil {
+			return nil, err
+		}
+		return []Shape{{Points: []Point{p}}}, nil
+	case "MultiPoint", "LineString":
+		var positions [][]float64
+		if err := json.Unmarshal(o.Coordinates, &positions); err != nil {
+			return nil, err
+		}
+		points, err := geoJSONPoints(positions)
+		if err != nil {
+			return nil, err
+		}
+		if o.Type == "LineString" {
+			return []Shape{{Points: points}}, nil
+		}
+		for _, p := range points {
+			shapes = append(shapes, Shape{Points: []Point{p}})
+		}
+		return shapes, nil
+	case "MultiLineString", "Polygon":
+		var lines [][][]float64
+		if err := json.Unmarshal(o.Coordinates, &lines); err != nil {
+			return nil, err
+		}
+		for i, positions := range lines {
+			// Only the outer ring of a polygon is used
+			if o.Type == "Polygon" && i > 0 {
+				break
+			}
+			points, err := geoJSONPoints(positions)
+			if err != nil {
+				return nil, err
+			}
+			shapes = append(shapes, Shape{Points: points, Closed: o.Type == "Polygon"})
+		}
+		return shapes, nil
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := json.Unmarshal(o.Coordinates, &polygons); err != nil {
+			return nil, err
+		}
+		for _, rings := range polygons {
+			if len(rings) == 0 {
+				continue
+			}
+			points, err := geoJSONPoints(rings[0])
+			if err != nil {
+				return nil, err
+			}
+			shapes = append(shapes, Shape{Points: points, Closed: true})
+		}
+		return shapes, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported GeoJSON type '%s'", o.Type)
+}
+
+// Convert a GeoJSON position (longitude, latitude, optional altitude) to a Point
+func geoJSONPoint(position []float64) (Point, error) {
+	if len(position) < 2 {
+		return Point{}, errors.New("GeoJSON position must have at least 2 elements")
+	}
+
+	return validPoint(position[1], position[0])
+}
+
+// Convert a list of GeoJSON positions to Points
+func geoJSONPoints(positions [][]float64) ([]Point, error) {
+	points := make([]Point, 0, len(positions))
+
+	for _, position := range positions {
+		p, err := geoJSONPoint(position)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	return points, nil
+}
+
+// Create a Point, checking that the coordinate lies on the globe
+func validPoint(lat float64, lng float64) (Point, error) {
+	if !(lat >= -90 && lat <= 90) || !(lng >= -180 && lng <= 180) {
+		return Point{}, fmt.Errorf("Coordinate (%f, %f) is out of range", lat, lng)
+	}
+
+	return Point{lat: lat, lng: lng}, nil
+}
+
+// Node of a parenthesized WKT coordinate list, either a coordinate tuple or a list of nodes
+type wktNode struct {
+	tuple    []float64
+	children []wktNode
+}
+
+// Maximum nesting of WKT coordinate lists, a MULTIPOLYGON needs 3 levels
+// The parser is recursive, so deeper nesting is rejected before it can exhaust the stack.
+const maxWKTListDepth = 4
+
+// Maximum nesting of WKT geometry collections
+const maxWKTCollectionDepth = 8
+
+// Reader for the tokens of a WKT string
+type wktReader struct {
+	tokens []string
+	pos    int
+}
+
+// Parse shapes from WKT or PostGIS EWKT, using the first two ordinates as longitude and latitude
+func parseWKT(text string) ([]Shape, error) {
+	text = strings.TrimSpace(text)
+
+	// EWKT from PostGIS is prefixed by the spatial reference, e.g. "SRID=4326;POINT(...)"
+	if strings.HasPrefix(strings.ToUpper(text), "SRID=") {
+		if i := strings.Index(text, ";"); i >= 0 {
+			text = text[i+1:]
+		}
+	}
+
+	r := &wktReader{tokens: tokenizeWKT(text)}
+
+	shapes, err := r.geometry(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.pos < len(r.tokens) {
+		return nil, fmt.Errorf("Unexpected '%s' after the end of the WKT geometry", r.tokens[r.pos])
+	}
+
+	return shapes, nil
+}
+
+// Split a WKT string into words, numbers and punctuation
+func tokenizeWKT(text string) []string {
+	tokens := make([]string, 0)
+	current := ""
+
+	for _, c := range text {
+		if c == '(' || c == ')' || c == ',' || unicode.IsSpace(c) {
+			if current != "" {
+				tokens = append(tokens, current)
+				current = ""
+			}
+			if !unicode.IsSpace(c) {
+				tokens = append(tokens, string(c))
+			}
+			continue
+		}
+		current += string(c)
+	}
+
+	if current != "" {
+		tokens = append(tokens, current)
+	}
+
+	return tokens
+}
+
+// Get the next token without consuming it
+func (r *wktReader) peek() string {
+	if r.pos < len(r.tokens) {
+		return r.tokens[r.pos]
+	}
+	return ""
+}
+
+// Consume the next token
+func (r *wktReader) next() string {
+	token := r.peek()
+	r.pos++
+	return token
+}
+
+// Parse a tagged WKT geometry at a nesting depth of geometry collections, starting at 1
+func (r *wktReader) geometry(depth int) ([]Shape, error) {
+	kind := strings.ToUpper(r.next())
+
+	// Skip the dimension of the coordinates
+	if dim := strings.ToUpper(r.peek()); dim == "Z" || dim == "M" || dim == "ZM" {
+		r.next()
+	}
+
+	if strings.ToUpper(r.peek()) == "EMPTY" {
+		r.next()
+		return nil, nil
+	}
+
+	if kind == "GEOMETRYCOLLECTION" {
+		if depth > maxWKTCollectionDepth {
+			return nil, fmt.Errorf("WKT geometry collections must not be nested deeper than %d levels", maxWKTCollectionDepth)
+		}
+
+		if r.next() != "(" {
+			return nil, errors.New("Expected '(' after GEOMETRYCOLLECTION")
+		}
+
+		var shapes []Shape
+
+		for {
+			s, err := r.geometry(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			shapes = append(shapes, s...)
+
+			token := r.next()
+			if token == ")" {
+				return shapes, nil
+			}
+			if token != "," {
+				return nil, fmt.Errorf("Unexpected '%s' in GEOMETRYCOLLECTION", token)
+			}
+		}
+	}
+
+	node, err := r.list(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "POINT":
+		points, err := wktPoints(node)
+		return pointShapes(points), err
+	case "MULTIPOINT":
+		// Both "MULTIPOINT(1 2, 3 4)" and "MULTIPOINT((1 2), (3 4))" are valid
+		var points []Point
+		for _, child := range node.children {
+			p, err := wktPoints(child)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, p...)
+		}
+		return pointShapes(points), nil
+	case "LINESTRING":
+		points, err := wktPoints(node)
+		return []Shape{{Points: points}}, err
+	case "MULTILINESTRING":
+		var shapes []Shape
+		for _, child := range node.children {
+			points, err := wktPoints(child)
+			if err != nil {
+				return nil, err
+			}
+			shapes = append(shapes, Shape{Points: points})
+		}
+		return shapes, nil
+	case "POLYGON":
+		return wktPolygon(node)
+	case "MULTIPOLYGON":
+		var shapes []Shape
+		for _, child := range node.children {
+			s, err := wktPolygon(child)
+			if err != nil {
+				return nil, err
+			}
+			shapes = append(shapes, s...)
+		}
+		return shapes, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported WKT geometry '%s'", kind)
+}
+
+// Parse a parenthesized list of coordinate tuples or nested lists at a nesting depth, starting at 1
+func (r *wktReader) list(depth int) (wktNode, error) {
+	node := wktNode{}
+
+	if depth > maxWKTListDepth {
+		return node, fmt.Errorf("WKT coordinate lists must not be nested deeper than %d levels", maxWKTListDepth)
+	}
+
+	if token := r.next(); token != "(" {
+		return node, fmt.Errorf("Expected '(' but got '%s'", token)
+	}
+
+	for {
+		if r.peek() == "(" {
+			child, err := r.list(depth + 1)
+			if err != nil {
+				return node, err
+			}
+			node.children = append(node.children, child)
+		} else {
+			tuple := wktNode{}
+			for r.peek() != "," && r.peek() != ")" && r.peek() != "" {
+				value, err := strconv.ParseFloat(r.next(), 64)
+				if err != nil {
+					return node, err
+				}
+				tuple.tuple = append(tuple.tuple, value)
+			}
+			node.children = append(node.children, tuple)
+		}
+
+		token := r.next()
+		if token == ")" {
+			return node, nil
+		}
+		if token != "," {
+			return node, fmt.Errorf("Expected ',' or ')' but got '%s'", token)
+		}
+	}
+}
+
+// Get the points of a list of coordinate tuples, or of a single tuple
+func wktPoints(node wktNode) ([]Point, error) {
+	if node.tuple != nil {
+		node = wktNode{children: []wktNode{node}}
+	}
+
+	points := make([]Point, 0, len(node.children))
+
+	for _, child := range node.children {
+		if len(child.tuple) < 2 {
+			return nil, errors.New("WKT coordinate must have at least 2 ordinates")
+		}
+
+		p, err := validPoint(child.tuple[1], child.tuple[0])
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	return points, nil
+}
+
+// Get the outer ring of a WKT polygon
+func wktPolygon(node wktNode) ([]Shape, error) {
+	if len(node.children) == 0 {
+		return nil, nil
+	}
+
+	points, err := wktPoints(node.children[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []Shape{{Points: points, Closed: true}}, nil
+}
+
+// Make a separate shape of every point
+func pointShapes(points []Point) []Shape {
+	shapes := make([]Shape, 0, len(points))
+
+	for _, p := range points {
+		shapes = append(shapes, Shape{Points: []Point{p}})
+	}
+
+	return shapes
+}
+
+// Parse shapes from all Point, LineString, LinearRing and gx:Track geometries of a KML document
+// Only rings of an outerBoundaryIs are used as polygons, the rings of an innerBoundaryIs are skipped.
+func parseKML(data []byte) ([]Shape, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	var shapes []Shape
+	var stack []string
+	var track []Point
+
+	for {
+		token, err := decoder.Token()
+
+		if err != nil {
+			if err == io.EOF {
+				return shapes, nil
+			}
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			if t.Name.Local == "Track" {
+				track = make([]Point, 0)
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			if t.Name.Local == "Track" && len(track) > 0 {
+				shapes = append(shapes, Shape{Points: track})
+				track = nil
+			}
+		case xml.CharData:
+			if len(stack) < 2 {
+				continue
+			}
+
+			element, parent := stack[len(stack)-1], stack[len(stack)-2]
+
+			if element == "coord" && parent == "Track" {
+				// gx:coord holds a single space separated "lng lat alt" tuple
+				fields := strings.Fields(string(t))
+				if len(fields) < 2 {
+					return nil, errors.New("KML gx:coord must have at least 2 values")
+				}
+				p, err := parseLngLat(fields[0], fields[1])
+				if err != nil {
+					return nil, err
+				}
+				track = append(track, p)
+				continue
+			}
+
+			if element != "coordinates" {
+				continue
+			}
+
+			points, err := parseKMLCoordinates(string(t))
+			if err != nil {
+				return nil, err
+			}
+
+			switch parent {
+			case "Point":
+				shapes = append(shapes, pointShapes(points)...)
+			case "LineString":
+				shapes = append(shapes, Shape{Points: points})
+			case "LinearRing":
+				if len(stack) >= 3 && stack[len(stack)-3] == "innerBoundaryIs" {
+					continue
+				}
+				shapes = append(shapes, Shape{Points: points, Closed: true})
+			}
+		}
+	}
+}
+
+// Parse the content of a KML coordinates element, a whitespace separated list of "lng,lat[,alt]" tuples
+func parseKMLCoordinates(text string) ([]Point, error) {
+	fields := strings.Fields(text)
+	points := make([]Point, 0, len(fields))
+
+	for _, field := range fields {
+		values := strings.Split(field, ",")
+
+		if len(values) < 2 {
+			return nil, fmt.Errorf("Invalid KML coordinate '%s'", field)
+		}
+
+		p, err := parseLngLat(values[0], values[1])
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	return points, nil
+}
+
+// Parse a Point from longitude and latitude strings
+func parseLngLat(lng string, lat string) (Point, error) {
+	longitude, err := strconv.ParseFloat(lng, 64)
+	if err != nil {
+		return Point{}, err
+	}
+
+	latitude, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return Point{}, err
+	}
+
+	return validPoint(latitude, longitude)
+}
+
+// GPX waypoint, route point or track point
+type gpxPoint struct {
+	Lat float64 `xml:"lat,attr"`
+	Lon float64 `xml:"lon,attr"`
+}
+
+// GPX document, only the coordinates are decoded
+type gpxDocument struct {
+	Waypoints []gpxPoint `xml:"wpt"`
+	Routes    []struct {
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// Parse shapes from a GPX document, every route and track segment is a line and every waypoint a point
+func parseGPX(data []byte) ([]Shape, error) {
+	var doc gpxDocument
+
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var shapes []Shape
+
+	lines := make([][]gpxPoint, 0)
+
+	for _, rte := range doc.Routes {
+		lines = append(lines, rte.Points)
+	}
+
+	for _, trk := range doc.Tracks {
+		for _, seg := range trk.Segments {
+			lines = append(lines, seg.Points)
+		}
+	}
+
+	for _, line := range lines {
+		points := make([]Point, 0, len(line))
+
+		for _, gp := range line {
+			p, err := validPoint(gp.Lat, gp.Lon)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, p)
+		}
+
+		if len(points) > 0 {
+			shapes = append(shapes, Shape{Points: points})
+		}
+	}
+
+	for _, wpt := range doc.Waypoints {
+		p, err := validPoint(wpt.Lat, wpt.Lon)
+		if err != nil {
+			return nil, err
+		}
+		shapes = append(shapes, Shape{Points: []Point{p}})
+	}
+
+	return shapes, nil
+}
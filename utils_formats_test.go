@@ -0,0 +1,246 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Count the points of all shapes and the closed shapes
+func countShapes(shapes []Shape) (points int, closed int) {
+	for _, s := range shapes {
+		points += len(s.Points)
+		if s.Closed {
+			closed++
+		}
+	}
+
+	return points, closed
+}
+
+func TestParseWKT(t *testing.T) {
+	tests := []struct {
+		wkt    string
+		shapes int
+		points int
+		closed int
+	}{
+		{wkt: "POINT(10 50)", shapes: 1, points: 1},
+		{wkt: "point (10 50)", shapes: 1, points: 1},
+		{wkt: "SRID=4326;POINT Z (10 50 300)", shapes: 1, points: 1},
+		{wkt: "POINT EMPTY", shapes: 0},
+		{wkt: "MULTIPOINT(10 50, 11 51)", shapes: 2, points: 2},
+		{wkt: "MULTIPOINT((10 50), (11 51))", shapes: 2, points: 2},
+		{wkt: "LINESTRING(10 50, 11 51, 12 50)", shapes: 1, points: 3},
+		{wkt: "LINESTRING M (10 50 1, 11 51 2)", shapes: 1, points: 2},
+		{wkt: "MULTILINESTRING((10 50, 11 51), (12 50, 13 51, 14 50))", shapes: 2, points: 5},
+		{wkt: "POLYGON((0 0, 4 0, 4 4, 0 4, 0 0))", shapes: 1, points: 5, closed: 1},
+		{wkt: "POLYGON((0 0, 4 0, 4 4, 0 4, 0 0), (1 1, 2 1, 2 2, 1 1))", shapes: 1, points: 5, closed: 1},
+		{wkt: "POLYGON EMPTY", shapes: 0},
+		{wkt: "MULTIPOLYGON(((0 0, 1 0, 1 1, 0 0)), ((5 5, 6 5, 6 6, 5 5), (5.1 5.1, 5.2 5.1, 5.2 5.2, 5.1 5.1)))", shapes: 2, points: 8, closed: 2},
+		{wkt: "GEOMETRYCOLLECTION(POINT(10 50), LINESTRING(10 50, 11 51))", shapes: 2, points: 3},
+		{wkt: "GEOMETRYCOLLECTION(POINT EMPTY, GEOMETRYCOLLECTION(POLYGON((0 0, 1 0, 1 1, 0 0))))", shapes: 1, points: 4, closed: 1},
+		{wkt: "GEOMETRYCOLLECTION EMPTY", shapes: 0},
+		{
+			wkt:    strings.Repeat("GEOMETRYCOLLECTION(", maxWKTCollectionDepth) + "POINT(10 50)" + strings.Repeat(")", maxWKTCollectionDepth),
+			shapes: 1,
+			points: 1,
+		},
+	}
+
+	for _, test := range tests {
+		shapes, err := parseWKT(test.wkt)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.wkt, err.Error())
+			continue
+		}
+
+		points, closed := countShapes(shapes)
+
+		if len(shapes) != test.shapes || points != test.points || closed != test.closed {
+			t.Errorf("%s: %d shapes, %d points, %d closed, expected %d, %d, %d",
+				test.wkt, len(shapes), points, closed, test.shapes, test.points, test.closed)
+		}
+	}
+}
+
+func TestParseWKTCoordinates(t *testing.T) {
+	shapes, err := parseWKT("POINT(16.5 48.25)")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// WKT gives the longitude first
+	if p := shapes[0].Points[0]; p.lng != 16.5 || p.lat != 48.25 {
+		t.Errorf("point parsed as %+v", p)
+	}
+}
+
+func TestParseWKTRejected(t *testing.T) {
+	tests := []struct {
+		wkt   string
+		error string
+	}{
+		{wkt: "", error: "Expected '('"},
+		{wkt: "CIRCLE(10 50)", error: "Unsupported"},
+		{wkt: "POINT(10)", error: "2 ordinates"},
+		{wkt: "POINT()", error: "2 ordinates"},
+		{wkt: "POINT(a b)", error: "invalid syntax"},
+		{wkt: "POINT(10 95)", error: "out of range"},
+		{wkt: "POINT(190 50)", error: "out of range"},
+		{wkt: "POINT(10 50", error: "Expected ',' or ')'"},
+		{wkt: "POINT 10 50", error: "Expected '('"},
+		{wkt: "POINT(10 50) POINT(11 51)", error: "after the end"},
+		{wkt: "LINESTRING((10 50, 11 51))", error: "2 ordinates"},
+		{wkt: "GEOMETRYCOLLECTION POINT(10 50)", error: "Expected '('"},
+		{wkt: "GEOMETRYCOLLECTION(POINT(10 50) POINT(11 51))", error: "Unexpected 'POINT'"},
+		{wkt: "GEOMETRYCOLLECTION(POINT(10 50)", error: "Unexpected ''"},
+		{wkt: "MULTIPOLYGON((((((0 0, 1 0, 1 1, 0 0)))))", error: "nested deeper"},
+		{wkt: "POINT" + strings.Repeat("(", 100000), error: "nested deeper"},
+		{
+			wkt:   strings.Repeat("GEOMETRYCOLLECTION(", maxWKTCollectionDepth+1) + "POINT(10 50)" + strings.Repeat(")", maxWKTCollectionDepth+1),
+			error: "nested deeper",
+		},
+		{wkt: strings.Repeat("GEOMETRYCOLLECTION(", 100000), error: "nested deeper"},
+	}
+
+	for _, test := range tests {
+		_, err := parseWKT(test.wkt)
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			name := test.wkt
+			if len(name) > 60 {
+				name = name[:60] + "..."
+			}
+			t.Errorf("%s: error %v, expected %q", name, err, test.error)
+		}
+	}
+}
+
+func TestParseKML(t *testing.T) {
+	kml := `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">
+<Document>
+	<Placemark><Point><coordinates>10,50,300</coordinates></Point></Placemark>
+	<Placemark><LineString><coordinates>10,50 11,51
+		12,50</coordinates></LineString></Placemark>
+	<Placemark><Polygon>
+		<outerBoundaryIs><LinearRing><coordinates>0,0 4,0 4,4 0,4 0,0</coordinates></LinearRing></outerBoundaryIs>
+		<innerBoundaryIs><LinearRing><coordinates>1,1 2,1 2,2 1,1</coordinates></LinearRing></innerBoundaryIs>
+	</Polygon></Placemark>
+	<Placemark><gx:Track><when>2020-01-01T00:00:00Z</when><gx:coord>10 50 300</gx:coord><gx:coord>10.1 50.1 310</gx:coord></gx:Track></Placemark>
+</Document>
+</kml>`
+
+	shapes, err := parseKML([]byte(kml))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	points, closed := countShapes(shapes)
+
+	if len(shapes) != 4 || points != 11 || closed != 1 {
+		t.Errorf("%d shapes, %d points, %d closed, expected 4, 11, 1", len(shapes), points, closed)
+	}
+}
+
+func TestParseKMLRejected(t *testing.T) {
+	tests := []struct {
+		kml   string
+		error string
+	}{
+		{kml: `<kml><Placemark><Point><coordinates>10</coordinates></Point></Placemark></kml>`, error: "Invalid KML coordinate"},
+		{kml: `<kml><Placemark><Point><coordinates>10,x</coordinates></Point></Placemark></kml>`, error: "invalid syntax"},
+		{kml: `<kml><Placemark><Point><coordinates>10,95</coordinates></Point></Placemark></kml>`, error: "out of range"},
+		{kml: `<kml><Placemark><Track><coord>10</coord></Track></Placemark></kml>`, error: "at least 2 values"},
+		{kml: `<kml><Placemark><Point><coordinates>10,50</Point></kml>`, error: "XML syntax error"},
+	}
+
+	for _, test := range tests {
+		_, err := parseKML([]byte(test.kml))
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: error %v, expected %q", test.kml, err, test.error)
+		}
+	}
+}
+
+func TestParseGPX(t *testing.T) {
+	gpx := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+	<wpt lat="50" lon="10"><name>Start</name></wpt>
+	<rte><rtept lat="50" lon="10"/><rtept lat="51" lon="11"/></rte>
+	<trk>
+		<trkseg><trkpt lat="50" lon="10"/><trkpt lat="50.001" lon="10.001"/><trkpt lat="50.002" lon="10.002"/></trkseg>
+		<trkseg><trkpt lat="51" lon="11"/></trkseg>
+		<trkseg></trkseg>
+	</trk>
+</gpx>`
+
+	shapes, err := parseGPX([]byte(gpx))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	points, closed := countShapes(shapes)
+
+	// Empty track segments are skipped
+	if len(shapes) != 4 || points != 7 || closed != 0 {
+		t.Errorf("%d shapes, %d points, %d closed, expected 4, 7, 0", len(shapes), points, closed)
+	}
+}
+
+func TestParseGPXRejected(t *testing.T) {
+	tests := []struct {
+		gpx   string
+		error string
+	}{
+		{gpx: `<gpx><wpt lat="95" lon="10"/></gpx>`, error: "out of range"},
+		{gpx: `<gpx><trk><trkseg><trkpt lat="50" lon="190"/></trkseg></trk></gpx>`, error: "out of range"},
+		{gpx: `<gpx><wpt lat="x" lon="10"/></gpx>`, error: "invalid syntax"},
+		{gpx: `<gpx><wpt lat="50" lon="10">`, error: "XML syntax error"},
+	}
+
+	for _, test := range tests {
+		_, err := parseGPX([]byte(test.gpx))
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: error %v, expected %q", test.gpx, err, test.error)
+		}
+	}
+}
+
+func TestParseShapes(t *testing.T) {
+	tests := []struct {
+		contentType string
+		data        string
+		shapes      int
+		error       string
+	}{
+		{contentType: "application/geo+json", data: `{"type": "Point", "coordinates": [10, 50]}`, shapes: 1},
+		{contentType: "text/plain; charset=utf-8", data: "POINT(10 50)", shapes: 1},
+		{contentType: "application/vnd.google-earth.kml+xml", data: `<kml><Point><coordinates>10,50</coordinates></Point></kml>`, shapes: 1},
+		{contentType: "application/gpx+xml", data: `<gpx><wpt lat="50" lon="10"/></gpx>`, shapes: 1},
+		{contentType: "text/plain", data: "GEOMETRYCOLLECTION EMPTY", error: "does not contain any geometry"},
+		{contentType: "image/png", data: "", error: "Unsupported Content-Type"},
+		{contentType: "", data: "POINT(10 50)", error: "Invalid Content-Type"},
+	}
+
+	for _, test := range tests {
+		shapes, err := parseShapes(test.contentType, []byte(test.data))
+
+		if test.error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("%s: error %v, expected %q", test.contentType, err, test.error)
+			}
+			continue
+		}
+
+		if err != nil || len(shapes) != test.shapes {
+			t.Errorf("%s: %d shapes, error %v, expected %d shapes", test.contentType, len(shapes), err, test.shapes)
+		}
+	}
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAddressNotFound is returned by a Geocoder when an address cannot be resolved
+var ErrAddressNotFound = errors.New("Could not resolve the address")
+
+// GoogleGeocodingHost is the endpoint of the Google Geocoding API
+const GoogleGeocodingHost = "https://maps.googleapis.com/maps/api/geocode/json"
+
+// MaxGeocodeCandidates is the maximum number of candidates returned for an address
+const MaxGeocodeCandidates = 10
+
+// Location is a candidate resolved for an address
+type Location struct {
+	Address string  `json:"formatted_address"`
+	Lat     float64 `json:"lat"`
+	Lng     float64 `json:"lng"`
+	// Viewport recommended for displaying the location if known, West is greater than East when it crosses the antimeridian
+	Viewport *Bounds `json:"viewport,omitempty"`
+}
+
+// Geocoder resolves an address to candidate locations, the best candidate first
+type Geocoder interface {
+	Geocode(address string) ([]Location, error)
+}
+
+// Create the geocoder selected by name
+func newGeocoder(name string, apiKey string, gazetteerPath string) (Geocoder, error) {
+	switch name {
+	case "google":
+		return NewGoogleGeocoder(apiKey)
+	case "geonames":
+		return NewGazetteerGeocoder(gazetteerPath)
+	}
+
+	return nil, fmt.Errorf("Unknown geocoder '%s'", name)
+}
+
+// GoogleGeocoder resolves addresses using the Google Geocoding API
+type GoogleGeocoder struct {
+	apiKey string
+}
+
+// NewGoogleGeocoder creates a geocoder for the Google Geocoding API
+func NewGoogleGeocoder(apiKey string) (*GoogleGeocoder, error) {
+	if apiKey == "" {
+		return nil, errors.New("The Google geocoder requires an API key")
+	}
+
+	return &GoogleGeocoder{apiKey: apiKey}, nil
+}
+
+// Coordinate of a Google Geocoding API response
+type googleLatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Response of the Google Geocoding API, only the members required for a Location are decoded
+type googleGeocodeResponse struct {
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message"`
+	Results      []struct {
+		FormattedAddress string `json:"formatted_address"`
+		Geometry         struct {
+			Location googleLatLng `json:"location"`
+			Viewport struct {
+				Northeast googleLatLng `json:"northeast"`
+				Southwest googleLatLng `json:"southwest"`
+			} `json:"viewport"`
+		} `json:"geometry"`
+	} `json:"results"`
+}
+
+// Geocode resolves an address using the Google Geocoding API
+func (g *GoogleGeocoder) Geocode(address string) ([]Location, error) {
+	params := url.Values{}
+	params.Set("address", address)
+	params.Set("key", g.apiKey)
+
+	data, err := downloadFile(GoogleGeocodingHost + "?" + params.Encode())
+
+	// The request URL holds the API key, so it must not end up in error messages
+	if urlErr, ok := err.(*url.Error); ok {
+		return nil, urlErr.Err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var resp googleGeocodeResponse
+
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Status == "ZERO_RESULTS" {
+		return nil, ErrAddressNotFound
+	}
+
+	if resp.Status != "OK" {
+		return nil, fmt.Errorf("%s %s", resp.Status, resp.ErrorMessage)
+	}
+
+	locations := make([]Location, 0, len(resp.Results))
+
+	for _, result := range resp.Results {
+		viewport := result.Geometry.Viewport
+
+		locations = append(locations, Location{
+			Address: result.FormattedAddress,
+			Lat:     result.Geometry.Location.Lat,
+			Lng:     result.Geometry.Location.Lng,
+			Viewport: &Bounds{
+				South: viewport.Southwest.Lat,
+				West:  viewport.Southwest.Lng,
+				North: viewport.Northeast.Lat,
+				East:  viewport.Northeast.Lng,
+			},
+		})
+
+		if len(locations) == MaxGeocodeCandidates {
+			break
+		}
+	}
+
+	return locations, nil
+}
+
+// Place of the GeoNames gazetteer
+type gazetteerPlace struct {
+	name       string
+	lat        float64
+	lng        float64
+	country    string
+	population int64
+}
+
+// GazetteerGeocoder resolves place names offline using a GeoNames dump file
+type GazetteerGeocoder struct {
+	// Places by lower-case name, sorted by descending population
+	places map[string][]gazetteerPlace
+}
+
+// MinAlternateNamePopulation is the population from which the alternate names of a place are indexed
+// Most places have many alternate names, indexing them all would take several times the memory of the dump file.
+const MinAlternateNamePopulation = 15000
+
+// NewGazetteerGeocoder creates a geocoder from a GeoNames cities dump file (e.g. cities15000.txt)
+// The whole gazetteer (allCountries.txt) is not supported, its millions of places would take gigabytes of memory.
+func NewGazetteerGeocoder(path string) (*GazetteerGeocoder, error) {
+	if path == "" {
+		return nil, errors.New("The geonames geocoder requires the path of a GeoNames dump file")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	g := &GazetteerGeocoder{places: make(map[string][]gazetteerPlace)}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		// geonameid, name, asciiname, alternatenames, latitude, longitude, feature class, feature code,
+		// country code, cc2, admin1-4 codes, population, elevation, dem, timezone, modification date
+		fields := strings.Split(scanner.Text(), "\t")
+
+		if len(fields) < 15 {
+			return nil, fmt.Errorf("%s:%d: expected at least 15 tab separated fields", path, line)
+		}
+
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err.Error())
+		}
+
+		lng, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err.Error())
+		}
+
+		population, _ := strconv.ParseInt(fields[14], 10, 64)
+
+		place := gazetteerPlace{name: fields[1], lat: lat, lng: lng, country: strings.ToLower(fields[8]), population: population}
+
+		names := map[string]bool{}
+		candidates := []string{fields[1], fields[2]}
+
+		if population >= MinAlternateNamePopulation {
+			candidates = append(candidates, strings.Split(fields[3], ",")...)
+		}
+
+		for _, name := range candidates {
+			name = normalizePlaceName(name)
+			if name != "" && !names[name] {
+				names[name] = true
+				g.places[name] = append(g.places[name], place)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, places := range g.places {
+		sort.SliceStable(places, func(i, j int) bool {
+			return places[i].population > places[j].population
+		})
+	}
+
+	return g, nil
+}
+
+// Geocode resolves a place name, optionally followed by a two-letter country code (e.g. "Suva, FJ")
+// Candidates are ordered by descending population, the gazetteer has no viewports.
+func (g *GazetteerGeocoder) Geocode(address string) ([]Location, error) {
+	places := g.places[normalizePlaceName(address)]
+
+	country := ""
+
+	if len(places) == 0 {
+		parts := strings.Split(address, ",")
+		places = g.places[normalizePlaceName(parts[0])]
+
+		if len(parts) > 1 {
+			country = normalizePlaceName(parts[len(parts)-1])
+		}
+	}
+
+	locations := make([]Location, 0)
+
+	for _, place := range places {
+		if len(country) == 2 && place.country != country {
+			continue
+		}
+
+		locations = append(locations, Location{
+			Address: place.name + ", " + strings.ToUpper(place.country),
+			Lat:     place.lat,
+			Lng:     place.lng,
+		})
+
+		if len(locations) == MaxGeocodeCandidates {
+			break
+		}
+	}
+
+	if len(locations) == 0 {
+		return nil, ErrAddressNotFound
+	}
+
+	return locations, nil
+}
+
+// MaxGeocodeCacheEntries is the maximum number of addresses kept by the geocode cache
+const MaxGeocodeCacheEntries = 10000
+
+// NegativeGeocodeTTL is the time an address which could not be resolved is remembered
+// Geocoders learn new places, so such an address is asked again once it expired.
+const NegativeGeocodeTTL = 24 * time.Hour
+
+// CachedGeocoder keeps the results of another geocoder, optionally persisted to a file
+// The least recently used addresses are evicted beyond MaxGeocodeCacheEntries.
+// The file holds a JSON entry per line, every stored address is appended and the file is rewritten
+// with the current entries only once it has grown to twice the maximum number of entries.
+type CachedGeocoder struct {
+	geocoder Geocoder
+	path     string
+
+	mu sync.Mutex
+	// Front of the list is the most recently used entry
+	order   *list.List
+	entries map[string]*list.Element
+
+	// Guards the file, which is written without holding mu
+	fileMu sync.Mutex
+	// Number of lines in the file
+	lines int
+}
+
+// Entry of the geocode cache, stored as a line of the cache file
+type geocodeEntry struct {
+	Address string `json:"address"`
+	// Candidates of the address, empty for an address which could not be resolved
+	Locations []Location `json:"locations"`
+	// Expiry of an address which could not be resolved as a Unix time, 0 for resolved addresses
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// Check whether an entry has expired
+func (e *geocodeEntry) expired(now time.Time) bool {
+	return e.Expires != 0 && now.Unix() >= e.Expires
+}
+
+// NewCachedGeocoder wraps a geocoder with a cache, which is loaded from and saved to path unless it is empty
+func NewCachedGeocoder(geocoder Geocoder, path string) (*CachedGeocoder, error) {
+	c := &CachedGeocoder{geocoder: geocoder, path: path, order: list.New(), entries: make(map[string]*list.Element)}
+
+	if path == "" {
+		return c, nil
+	}
+
+	file, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	now := time.Now()
+	invalid := false
+
+	for scanner.Scan() {
+		c.lines++
+
+		var entry geocodeEntry
+
+		// A crash while appending may leave a truncated last line
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("%s: skipping invalid line %d", path, c.lines)
+			invalid = true
+			continue
+		}
+
+		if entry.expired(now) {
+			c.remove(entry.Address)
+			continue
+		}
+
+		c.add(&entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	// Lines appended to a truncated line would be lost as well
+	if invalid {
+		if err := c.compact(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Geocode resolves an address from the cache, asking the wrapped geocoder on a miss
+func (c *CachedGeocoder) Geocode(address string) ([]Location, error) {
+	key := normalizePlaceName(address)
+
+	locations, ok := c.get(key)
+
+	if !ok {
+		var err error
+
+		locations, err = c.geocoder.Geocode(address)
+
+		// Upstream failures are not cached, they may succeed on the next request
+		if err != nil && err != ErrAddressNotFound {
+			return nil, err
+		}
+
+		if err := c.store(key, locations); err != nil {
+			log.Printf("Could not save the geocode cache: %s", err.Error())
+		}
+	}
+
+	if len(locations) == 0 {
+		return nil, ErrAddressNotFound
+	}
+
+	return locations, nil
+}
+
+// Get the candidates of an address, expired entries are removed
+func (c *CachedGeocoder) get(key string) ([]Location, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*geocodeEntry)
+
+	if entry.expired(time.Now()) {
+		c.remove(key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return entry.Locations, true
+}
+
+// Store the candidates of an address and append them to the file
+// An address which could not be resolved is stored with an expiry.
+func (c *CachedGeocoder) store(key string, locations []Location) error {
+	entry := &geocodeEntry{Address: key, Locations: locations}
+
+	if len(locations) == 0 {
+		entry.Locations = []Location{}
+		entry.Expires = time.Now().Add(NegativeGeocodeTTL).Unix()
+	}
+
+	c.mu.Lock()
+	c.add(entry)
+	c.mu.Unlock()
+
+	if c.path == "" {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+
+	if c.lines >= 2*MaxGeocodeCacheEntries {
+		// The rewritten file already holds the new entry
+		return c.compact()
+	}
+
+	file, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c.lines++
+
+	return nil
+}
+
+// Rewrite the file with the current entries, the least recently used first
+// The file is replaced atomically so that a crash never leaves a truncated cache behind.
+func (c *CachedGeocoder) compact() error {
+	var buf bytes.Buffer
+
+	c.mu.Lock()
+
+	lines := c.order.Len()
+
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		line, err := json.Marshal(elem.Value.(*geocodeEntry))
+
+		if err != nil {
+			c.mu.Unlock()
+			return err
+		}
+
+		buf.Write(append(line, '\n'))
+	}
+
+	c.mu.Unlock()
+
+	tmp := c.path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+
+	c.lines = lines
+
+	return nil
+}
+
+// Add an entry, evicting the least recently used entries beyond MaxGeocodeCacheEntries
+func (c *CachedGeocoder) add(entry *geocodeEntry) {
+	c.remove(entry.Address)
+
+	c.entries[entry.Address] = c.order.PushFront(entry)
+
+	for c.order.Len() > MaxGeocodeCacheEntries {
+		c.remove(c.order.Back().Value.(*geocodeEntry).Address)
+	}
+}
+
+// Remove an entry
+func (c *CachedGeocoder) remove(key string) {
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// Normalize a place name for lookups
+func normalizePlaceName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
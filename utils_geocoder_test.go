@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Get a line of a GeoNames dump file
+func geoNamesLine(name string, alternates string, lat, lng, country, population string) string {
+	return strings.Join([]string{"1", name, name, alternates, lat, lng, "P", "PPL", country, "", "", "", "", "", population, "", "", "", ""}, "\t")
+}
+
+func TestGazetteerGeocoder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cities.txt")
+	data := strings.Join([]string{
+		geoNamesLine("Wien", "Vienna,Vienne,Bécs", "48.20849", "16.37208", "AT", "1691468"),
+		geoNamesLine("Vienna", "", "38.90122", "-77.26526", "US", "16489"),
+		geoNamesLine("Kleinhof", "Vienna", "47.1", "15.1", "AT", "120"),
+	}, "\n") + "\n"
+
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGazetteerGeocoder(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Alternate names of small places are not indexed
+	locations, err := g.Geocode("Vienna")
+
+	if err != nil || len(locations) != 2 || locations[0].Address != "Wien, AT" || locations[1].Address != "Vienna, US" {
+		t.Errorf("Vienna resolved to %+v, error %v", locations, err)
+	}
+
+	locations, err = g.Geocode("Vienna, US")
+
+	if err != nil || len(locations) != 1 || locations[0].Address != "Vienna, US" {
+		t.Errorf("Vienna, US resolved to %+v, error %v", locations, err)
+	}
+
+	if locations, err := g.Geocode("Kleinhof"); err != nil || len(locations) != 1 {
+		t.Errorf("Kleinhof resolved to %+v, error %v", locations, err)
+	}
+
+	if _, err := g.Geocode("Atlantis"); err != ErrAddressNotFound {
+		t.Errorf("Atlantis resolved with error %v", err)
+	}
+}
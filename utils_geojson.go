@@ -0,0 +1,22 @@
+package main
+
+// GeoJSONFeatureCollection is a GeoJSON object holding a list of features
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+	// Properties is a foreign member holding information about the collection as a whole
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// GeoJSONFeature is a GeoJSON object holding a geometry and its properties
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GeoJSONGeometry is a GeoJSON geometry, coordinates are given as (longitude, latitude)
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// ErrGranuleNotFound is returned when a granule is not part of the index
+var ErrGranuleNotFound = errors.New("Granule not found")
+
+// Granule ids consist of letters, digits, underscores and dots only
+var granuleIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// GranuleDetails describes a single granule with its metadata and files
+type GranuleDetails struct {
+	Index    IndexEntry       `json:"index"`
+	Product  *ProductMetadata `json:"product"`
+	Tile     *TileMetadata    `json:"tile"`
+	Bands    []GranuleFile    `json:"bands"`
+	Previews []GranuleFile    `json:"previews"`
+}
+
+// GranuleFile is a single file of a granule in Google Cloud Storage
+type GranuleFile struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+	// Band and resolution parsed from the name, empty for files not named like band images
+	Band       string `json:"band,omitempty"`
+	Resolution int    `json:"resolution,omitempty"`
+	// Checksums as stored by Google Cloud Storage, the MD5 hash is hex encoded
+	MD5    string `json:"md5,omitempty"`
+	CRC32C uint32 `json:"crc32c"`
+	// Size and checksum listed in the manifest of the product
+	Manifest *FileChecksum `json:"manifest,omitempty"`
+	// Whether the MD5 hash of the stored file was compared with the manifest and matches it,
+	// files listed with SHA3-256 checksums cannot be verified without downloading them
+	Verified bool `json:"verified"`
+
+	object string
+}
+
+// Get the details of a single granule, the result is cached
+func (s *Server) getGranuleDetails(id string) (*GranuleDetails, error) {
+	key := metadataKey(id, "details")
+
+	var cached GranuleDetails
+
+	if s.metadata.get(key, &cached) {
+		return &cached, nil
+	}
+
+	granule, entry, err := s.getIndexEntry(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := s.getGranuleProduct(granule)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tile, err := s.getGranuleTile(granule)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bands, err := s.getGranuleFiles(granule.imagePath(), func(name string) bool {
+		return path.Ext(name) == ".jp2"
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	previews, err := s.getGranuleFiles(granule.qualityPath(), func(name string) bool {
+		return strings.HasSuffix(name, "PVI.jp2")
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := s.getManifest(granule.productPath())
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.check(bands, granule.productPath())
+	manifest.check(previews, granule.productPath())
+
+	details := &GranuleDetails{Index: entry, Product: product, Tile: tile, Bands: bands, Previews: previews}
+
+	s.metadata.put(key, details)
+
+	return details, nil
+}
+
+// Get the index entry of a single granule
+func (s *Server) getIndexEntry(id string) (Granule, IndexEntry, error) {
+	if !granuleIDPattern.MatchString(id) {
+		return Granule{}, IndexEntry{}, ErrGranuleNotFound
+	}
+
+	ctx := context.Background()
+
+	sql := `SELECT ` + strings.Join(indexColumns, ", ") +
+		" FROM `bigquery-public-data.cloud_storage_geo_index.sentinel_2_index` " +
+		`WHERE granule_id = @id LIMIT 1`
+
+	query := s.bigquery.Query(sql)
+	query.QueryConfig.UseStandardSQL = true
+	query.QueryConfig.Parameters = []bigquery.QueryParameter{{Name: "id", Value: id}}
+
+	dbit, err := query.Read(ctx)
+
+	if err != nil {
+		return Granule{}, IndexEntry{}, err
+	}
+
+	var row indexRow
+
+	err = dbit.Next(&row)
+
+	if err == iterator.Done {
+		return Granule{}, IndexEntry{}, ErrGranuleNotFound
+	}
+
+	if err != nil {
+		return Granule{}, IndexEntry{}, err
+	}
+
+	if err := validateSchema(dbit.Schema, indexColumns); err != nil {
+		return Granule{}, IndexEntry{}, err
+	}
+
+	return row.entry()
+}
+
+// Get all files below a path in Google Cloud Storage whose name matches
+func (s *Server) getGranuleFiles(prefix string, match func(name string) bool) ([]GranuleFile, error) {
+	ctx := context.Background()
+
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+
+	files := make([]GranuleFile, 0)
+
+	for {
+		objAttrs, err := it.Next()
+
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !match(objAttrs.Name) {
+			continue
+		}
+
+		file := GranuleFile{
+			Name:   path.Base(objAttrs.Name),
+			URL:    objAttrs.MediaLink,
+			Size:   objAttrs.Size,
+			MD5:    hex.EncodeToString(objAttrs.MD5),
+			CRC32C: objAttrs.CRC32C,
+			object: objAttrs.Name,
+		}
+
+		if band, err := parseBandName(file.Name); err == nil {
+			file.Band = band.Band
+			file.Resolution = band.Resolution
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"image"
 	"image/jpeg"
-	"net/http"
 )
 
 func getBandRating(url string, band string) (int, error) {
 	// You can register another format here
 	image.RegisterFormat("jp2", "jp2", jpeg.Decode, jpeg.DecodeConfig)
 
-	// pixels, err := getPixels(url)
+	// pixels, err := getPixels(url, checksum)
 
 	// if err != nil {
 	// 	return 0, err
@@ -19,18 +19,16 @@ func getBandRating(url string, band string) (int, error) {
 	return 0, nil
 }
 
-// Get the bi-dimensional pixel array
-func getPixels(url string) ([][]Pixel, error) {
+// Get the bi-dimensional pixel array of an image, verifying its download against the checksum of the file
+func getPixels(url string, checksum FileChecksum) ([][]Pixel, error) {
 
-	resp, err := http.Get(url)
+	data, err := downloadVerified(url, checksum)
 
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-
-	img, _, err := image.Decode(resp.Body)
+	img, _, err := image.Decode(bytes.NewReader(data))
 
 	if err != nil {
 		return nil, err
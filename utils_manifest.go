@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/crypto/sha3"
+	"golang.org/x/net/context"
+)
+
+// FileChecksum holds the size and checksum a file is expected to have
+type FileChecksum struct {
+	Size int64 `json:"size"`
+	// Algorithm of the checksum, "MD5" or "SHA3-256" for products of processing baseline 04.00 and later,
+	// empty when only the size is known
+	Algorithm string `json:"algorithm,omitempty"`
+	// Hex encoded checksum
+	Value string `json:"value,omitempty"`
+}
+
+// Get the hex encoded checksum of data computed with the algorithm of the checksum, false for unsupported algorithms
+func (c FileChecksum) sum(data []byte) (string, bool) {
+	switch c.Algorithm {
+	case "MD5":
+		sum := md5.Sum(data)
+		return hex.EncodeToString(sum[:]), true
+	case "SHA3-256":
+		sum := sha3.Sum256(data)
+		return hex.EncodeToString(sum[:]), true
+	}
+
+	return "", false
+}
+
+// Verify that data has the expected size and checksum
+// Checksums of unsupported algorithms are ignored and only the size is compared.
+func (c FileChecksum) verify(data []byte) error {
+	if int64(len(data)) != c.Size {
+		return fmt.Errorf("expected %d bytes, got %d", c.Size, len(data))
+	}
+
+	if c.Value == "" {
+		return nil
+	}
+
+	sum, ok := c.sum(data)
+
+	if ok && sum != strings.ToLower(c.Value) {
+		return fmt.Errorf("%s checksum mismatch, expected %s", c.Algorithm, c.Value)
+	}
+
+	return nil
+}
+
+// Check whether a file stored with a size and MD5 hash is verified by the checksum
+// Only MD5 checksums can be compared with the hashes stored by Google Cloud Storage,
+// so a file is never verified by any other checksum or by its size alone.
+func (c FileChecksum) matches(size int64, md5Hash []byte) bool {
+	if size != c.Size || c.Algorithm != "MD5" || len(md5Hash) == 0 {
+		return false
+	}
+
+	expected, err := hex.DecodeString(c.Value)
+
+	return err == nil && bytes.Equal(expected, md5Hash)
+}
+
+// Manifest holds the checksums of all files of a product by their path relative to the product directory
+type Manifest map[string]FileChecksum
+
+// Layout of manifest.safe
+type manifestXML struct {
+	DataObjects []struct {
+		ByteStreams []struct {
+			Size     int64 `xml:"size,attr"`
+			Location struct {
+				Href string `xml:"href,attr"`
+			} `xml:"fileLocation"`
+			Checksum struct {
+				Name  string `xml:"checksumName,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"checksum"`
+		} `xml:"byteStream"`
+	} `xml:"dataObjectSection>dataObject"`
+}
+
+// Parse the manifest of a SAFE product
+func parseManifest(data []byte) (Manifest, error) {
+	var doc manifestXML
+
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	manifest := make(Manifest)
+
+	for _, object := range doc.DataObjects {
+		for _, stream := range object.ByteStreams {
+			name := strings.TrimPrefix(stream.Location.Href, "./")
+
+			if name == "" {
+				continue
+			}
+
+			manifest[name] = FileChecksum{
+				Size:      stream.Size,
+				Algorithm: strings.ToUpper(strings.TrimSpace(stream.Checksum.Name)),
+				Value:     strings.ToLower(strings.TrimSpace(stream.Checksum.Value)),
+			}
+		}
+	}
+
+	return manifest, nil
+}
+
+// Set the manifest checksums of files of a product and check the stored files against them
+func (m Manifest) check(files []GranuleFile, productPath string) {
+	for i := range files {
+		checksum, ok := m[strings.TrimPrefix(files[i].object, productPath)]
+
+		if !ok {
+			continue
+		}
+
+		md5Hash, _ := hex.DecodeString(files[i].MD5)
+
+		files[i].Manifest = &checksum
+		files[i].Verified = checksum.matches(files[i].Size, md5Hash)
+	}
+}
+
+// Get the path of the product directory holding a file, ending with a slash, false when the file is not part of a product
+func productPathOf(filePath string) (string, bool) {
+	i := strings.Index(filePath, ".SAFE/")
+
+	if i < 0 {
+		return "", false
+	}
+
+	return filePath[:i+len(".SAFE/")], true
+}
+
+// Get the manifest of the product at a specific path in Google Cloud Storage, nil if the product has no manifest
+// The manifest is cached by the product path, also when the product has no manifest.
+func (s *Server) getManifest(productPath string) (Manifest, error) {
+	key := metadataKey(productPath, "manifest")
+
+	var cached Manifest
+
+	if s.metadata.get(key, &cached) {
+		return cached, nil
+	}
+
+	ctx := context.Background()
+
+	reader, err := s.bucket.Object(productPath + "manifest.safe").NewReader(ctx)
+
+	if err == storage.ErrObjectNotExist {
+		s.metadata.put(key, Manifest(nil))
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := parseManifest(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.metadata.put(key, manifest)
+
+	return manifest, nil
+}
+
+// Get the manifest as the result of a GranuleRequest
+func (s *Server) getManifestResult(productPath string) GranuleResult {
+	manifest, err := s.getManifest(productPath)
+
+	return GranuleResult{manifest: manifest, err: err}
+}
+
+// Get the checksum a stored file is downloaded with
+// The checksum is taken from the manifest of the product holding the file, the size and MD5 hash stored
+// by Google Cloud Storage are only used for files missing from the manifest.
+func (s *Server) fileChecksum(objAttrs *storage.ObjectAttrs) (FileChecksum, error) {
+	if productPath, ok := productPathOf(objAttrs.Name); ok {
+		manifest, err := s.getManifest(productPath)
+
+		if err != nil {
+			return FileChecksum{}, err
+		}
+
+		if checksum, ok := manifest[strings.TrimPrefix(objAttrs.Name, productPath)]; ok {
+			return checksum, nil
+		}
+	}
+
+	checksum := FileChecksum{Size: objAttrs.Size}
+
+	if len(objAttrs.MD5) > 0 {
+		checksum.Algorithm = "MD5"
+		checksum.Value = hex.EncodeToString(objAttrs.MD5)
+	}
+
+	return checksum, nil
+}
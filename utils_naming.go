@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of all times in Sentinel-2 names
+const namingTimeLayout = "20060102T150405"
+
+// Names of products, granules and band files in the compact convention used since December 2016,
+// and in the longer convention used before
+var (
+	compactProductPattern = regexp.MustCompile(`^(S2[A-D])_MSI(L1C|L2A)_(\d{8}T\d{6})_N(\d{2})(\d{2})_R(\d{3})_T(\d{2}[A-Z]{3})_(\d{8}T\d{6})$`)
+	oldProductPattern     = regexp.MustCompile(`^(S2[A-D])_([A-Z0-9_]{4})_PRD_MSI(L1C|L2A)_([A-Z0-9_]{4})_(\d{8}T\d{6})_R(\d{3})_V(\d{8}T\d{6})_(\d{8}T\d{6})$`)
+	compactGranulePattern = regexp.MustCompile(`^(L1C|L2A)_T(\d{2}[A-Z]{3})_A(\d{6})_(\d{8}T\d{6})$`)
+	oldGranulePattern     = regexp.MustCompile(`^(S2[A-D])_([A-Z0-9_]{4})_MSI_(L1C|L2A)_TL_([A-Z0-9_]{4})_(\d{8}T\d{6})_A(\d{6})_T(\d{2}[A-Z]{3})(?:_N(\d{2}\.\d{2}))?$`)
+	compactBandPattern    = regexp.MustCompile(`^T(\d{2}[A-Z]{3})_(\d{8}T\d{6})_([A-Z0-9]{3})(?:_(\d{2})m)?\.jp2$`)
+	oldBandPattern        = regexp.MustCompile(`^(S2[A-D]_[A-Z0-9_]{4}_MSI_(?:L1C|L2A)_TL_[A-Z0-9_]{4}_\d{8}T\d{6}_A\d{6}_T\d{2}[A-Z]{3})_([A-Z0-9]{3})(?:_(\d{2})m)?\.jp2$`)
+)
+
+// ProductName identifies a Sentinel-2 product, such as S2A_MSIL1C_20170105T013442_N0204_R031_T53NMJ_20170105T013443
+// or S2A_OPER_PRD_MSIL1C_PDMC_20160202T130020_R094_V20160202T080035_20160202T080035 before December 2016
+type ProductName struct {
+	Compact bool
+	// Satellite such as S2A
+	Mission string
+	// Processing level, L1C or L2A
+	Level         string
+	SensingTime   time.Time
+	RelativeOrbit int
+	// Processing baseline such as "02.04", not part of names of the old convention
+	Baseline string
+	// Tile such as 53NMJ, not part of names of the old convention, since such products hold many tiles
+	Tile string
+	// Discriminator of products with the same sensing time, compact convention only
+	Discriminator time.Time
+	// File class, site centre, creation time and end of the validity, old convention only
+	FileClass    string
+	SiteCentre   string
+	CreationTime time.Time
+	ValidityStop time.Time
+}
+
+// Parse the name of a product, with or without the .SAFE extension
+func parseProductName(name string) (ProductName, error) {
+	name = strings.TrimSuffix(name, ".SAFE")
+
+	if m := compactProductPattern.FindStringSubmatch(name); m != nil {
+		p := ProductName{Compact: true, Mission: m[1], Level: m[2], Baseline: m[4] + "." + m[5], Tile: m[7]}
+
+		var err error
+
+		if p.SensingTime, err = parseNamingTime(m[3]); err != nil {
+			return p, err
+		}
+
+		if p.Discriminator, err = parseNamingTime(m[8]); err != nil {
+			return p, err
+		}
+
+		p.RelativeOrbit, _ = strconv.Atoi(m[6])
+
+		return p, nil
+	}
+
+	if m := oldProductPattern.FindStringSubmatch(name); m != nil {
+		p := ProductName{Mission: m[1], FileClass: m[2], Level: m[3], SiteCentre: m[4]}
+
+		var err error
+
+		if p.CreationTime, err = parseNamingTime(m[5]); err != nil {
+			return p, err
+		}
+
+		// Products of the old convention are named by their validity, which starts with the sensing
+		if p.SensingTime, err = parseNamingTime(m[7]); err != nil {
+			return p, err
+		}
+
+		if p.ValidityStop, err = parseNamingTime(m[8]); err != nil {
+			return p, err
+		}
+
+		p.RelativeOrbit, _ = strconv.Atoi(m[6])
+
+		return p, nil
+	}
+
+	return ProductName{}, fmt.Errorf("invalid product name %s", name)
+}
+
+// Format the name of the product, without the .SAFE extension
+func (p ProductName) String() string {
+	if p.Compact {
+		return fmt.Sprintf("%s_MSI%s_%s_N%s_R%03d_T%s_%s", p.Mission, p.Level, p.SensingTime.Format(namingTimeLayout),
+			strings.Replace(p.Baseline, ".", "", 1), p.RelativeOrbit, p.Tile, p.Discriminator.Format(namingTimeLayout))
+	}
+
+	return fmt.Sprintf("%s_%s_PRD_MSI%s_%s_%s_R%03d_V%s_%s", p.Mission, p.FileClass, p.Level, p.SiteCentre,
+		p.CreationTime.Format(namingTimeLayout), p.RelativeOrbit,
+		p.SensingTime.Format(namingTimeLayout), p.ValidityStop.Format(namingTimeLayout))
+}
+
+// Format the name of the product level metadata file, such as MTD_MSIL1C.xml
+func (p ProductName) metadataName() string {
+	if p.Compact {
+		return "MTD_MSI" + p.Level + ".xml"
+	}
+
+	return fmt.Sprintf("%s_%s_MTD_SAF%s_%s_%s_R%03d_V%s_%s.xml", p.Mission, p.FileClass, p.Level, p.SiteCentre,
+		p.CreationTime.Format(namingTimeLayout), p.RelativeOrbit,
+		p.SensingTime.Format(namingTimeLayout), p.ValidityStop.Format(namingTimeLayout))
+}
+
+// Get the time the product was processed at, which tells apart products of the same acquisition and baseline
+func (p ProductName) processingTime() time.Time {
+	if p.Compact {
+		return p.Discriminator
+	}
+
+	return p.CreationTime
+}
+
+// GranuleName identifies a granule within a product, such as L1C_T53NMJ_A008040_20170105T013443
+// or S2A_OPER_MSI_L1C_TL_SGS__20160202T123456_A003123_T36PXT_N02.01 before December 2016
+type GranuleName struct {
+	Compact bool
+	// Processing level, L1C or L2A
+	Level         string
+	Tile          string
+	AbsoluteOrbit int
+	// Start of the datatake, compact convention only
+	SensingTime time.Time
+	// Satellite, file class, site centre, creation time and processing baseline, old convention only
+	Mission      string
+	FileClass    string
+	SiteCentre   string
+	CreationTime time.Time
+	Baseline     string
+}
+
+// Parse the name of a granule
+func parseGranuleName(name string) (GranuleName, error) {
+	if m := compactGranulePattern.FindStringSubmatch(name); m != nil {
+		g := GranuleName{Compact: true, Level: m[1], Tile: m[2]}
+
+		var err error
+
+		if g.SensingTime, err = parseNamingTime(m[4]); err != nil {
+			return g, err
+		}
+
+		g.AbsoluteOrbit, _ = strconv.Atoi(m[3])
+
+		return g, nil
+	}
+
+	if m := oldGranulePattern.FindStringSubmatch(name); m != nil {
+		g := GranuleName{Mission: m[1], FileClass: m[2], Level: m[3], SiteCentre: m[4], Tile: m[7], Baseline: m[8]}
+
+		var err error
+
+		if g.CreationTime, err = parseNamingTime(m[5]); err != nil {
+			return g, err
+		}
+
+		g.AbsoluteOrbit, _ = strconv.Atoi(m[6])
+
+		return g, nil
+	}
+
+	return GranuleName{}, fmt.Errorf("invalid granule name %s", name)
+}
+
+// Format the name of the granule
+func (g GranuleName) String() string {
+	if g.Compact {
+		return fmt.Sprintf("%s_T%s_A%06d_%s", g.Level, g.Tile, g.AbsoluteOrbit, g.SensingTime.Format(namingTimeLayout))
+	}
+
+	name := g.prefix("MSI")
+
+	if g.Baseline != "" {
+		name += "_N" + g.Baseline
+	}
+
+	return name
+}
+
+// Format the name of a granule of the old convention with a file type, without the processing baseline
+func (g GranuleName) prefix(fileType string) string {
+	return fmt.Sprintf("%s_%s_%s_%s_TL_%s_%s_A%06d_T%s", g.Mission, g.FileClass, fileType, g.Level, g.SiteCentre,
+		g.CreationTime.Format(namingTimeLayout), g.AbsoluteOrbit, g.Tile)
+}
+
+// Format the name of the granule level metadata file, such as MTD_TL.xml
+func (g GranuleName) metadataName() string {
+	if g.Compact {
+		return "MTD_TL.xml"
+	}
+
+	return g.prefix("MTD") + ".xml"
+}
+
+// BandName identifies an image file of a granule, such as T53NMJ_20170105T013442_B02.jp2,
+// T32TQM_20180102T101234_B02_10m.jp2 for Level-2A or S2A_OPER_MSI_L1C_TL_SGS__20160202T123456_A003123_T36PXT_B02.jp2
+// before December 2016
+type BandName struct {
+	Compact bool
+	// Band such as B02 or B8A, or a product such as TCI or SCL
+	Band string
+	// Resolution in meters, Level-2A only
+	Resolution int
+	// Tile and sensing time, compact convention only
+	Tile        string
+	SensingTime time.Time
+	// Granule the file belongs to, old convention only
+	Granule GranuleName
+}
+
+// Parse the file name of a band image
+func parseBandName(name string) (BandName, error) {
+	if m := compactBandPattern.FindStringSubmatch(name); m != nil {
+		b := BandName{Compact: true, Tile: m[1], Band: m[3]}
+
+		var err error
+
+		if b.SensingTime, err = parseNamingTime(m[2]); err != nil {
+			return b, err
+		}
+
+		b.Resolution, _ = strconv.Atoi(m[4])
+
+		return b, nil
+	}
+
+	if m := oldBandPattern.FindStringSubmatch(name); m != nil {
+		granule, err := parseGranuleName(m[1])
+
+		if err != nil {
+			return BandName{}, err
+		}
+
+		b := BandName{Granule: granule, Band: m[2]}
+		b.Resolution, _ = strconv.Atoi(m[3])
+
+		return b, nil
+	}
+
+	return BandName{}, fmt.Errorf("invalid band file name %s", name)
+}
+
+// Format the file name of the band image
+func (b BandName) String() string {
+	suffix := b.Band
+
+	if b.Resolution != 0 {
+		suffix += fmt.Sprintf("_%02dm", b.Resolution)
+	}
+
+	if b.Compact {
+		return fmt.Sprintf("T%s_%s_%s.jp2", b.Tile, b.SensingTime.Format(namingTimeLayout), suffix)
+	}
+
+	return b.Granule.prefix("MSI") + "_" + suffix + ".jp2"
+}
+
+// Get the path of the directory holding all products of a tile in the bucket, such as tiles/53/N/MJ/
+// Level-2A products are stored below L2/.
+func tilePath(level string, tile string) string {
+	zone, _ := strconv.Atoi(tile[:2])
+
+	path := fmt.Sprintf("tiles/%d/%s/%s/", zone, tile[2:3], tile[3:])
+
+	if level == "L2A" {
+		path = "L2/" + path
+	}
+
+	return path
+}
+
+// Parse a time of a name
+func parseNamingTime(value string) (time.Time, error) {
+	return time.Parse(namingTimeLayout, value)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Parse a time of a name, failing the test if it is invalid
+func namingTime(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	v, err := parseNamingTime(value)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func TestProductNameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		compact      bool
+		level        string
+		baseline     string
+		tile         string
+		orbit        int
+		sensingTime  string
+		metadataName string
+	}{
+		{
+			name:         "S2A_MSIL1C_20170105T013442_N0204_R031_T53NMJ_20170105T013443",
+			compact:      true,
+			level:        "L1C",
+			baseline:     "02.04",
+			tile:         "53NMJ",
+			orbit:        31,
+			sensingTime:  "20170105T013442",
+			metadataName: "MTD_MSIL1C.xml",
+		},
+		{
+			name:         "S2B_MSIL2A_20180102T101234_N0206_R022_T32TQM_20180102T121530",
+			compact:      true,
+			level:        "L2A",
+			baseline:     "02.06",
+			tile:         "32TQM",
+			orbit:        22,
+			sensingTime:  "20180102T101234",
+			metadataName: "MTD_MSIL2A.xml",
+		},
+		{
+			name:         "S2A_OPER_PRD_MSIL1C_PDMC_20160202T130020_R094_V20160202T080035_20160202T080035",
+			level:        "L1C",
+			orbit:        94,
+			sensingTime:  "20160202T080035",
+			metadataName: "S2A_OPER_MTD_SAFL1C_PDMC_20160202T130020_R094_V20160202T080035_20160202T080035.xml",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := parseProductName(test.name + ".SAFE")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Compact != test.compact || p.Level != test.level || p.Baseline != test.baseline || p.Tile != test.tile || p.RelativeOrbit != test.orbit {
+				t.Errorf("unexpected fields %+v", p)
+			}
+
+			if !p.SensingTime.Equal(namingTime(t, test.sensingTime)) {
+				t.Errorf("sensing time %s, expected %s", p.SensingTime, test.sensingTime)
+			}
+
+			if p.String() != test.name {
+				t.Errorf("formatted as %s", p.String())
+			}
+
+			if p.metadataName() != test.metadataName {
+				t.Errorf("metadata name %s, expected %s", p.metadataName(), test.metadataName)
+			}
+		})
+	}
+}
+
+func TestGranuleNameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		compact      bool
+		level        string
+		tile         string
+		orbit        int
+		baseline     string
+		metadataName string
+	}{
+		{
+			name:         "L1C_T53NMJ_A008040_20170105T013443",
+			compact:      true,
+			level:        "L1C",
+			tile:         "53NMJ",
+			orbit:        8040,
+			metadataName: "MTD_TL.xml",
+		},
+		{
+			name:         "L2A_T32TQM_A013456_20180102T101530",
+			compact:      true,
+			level:        "L2A",
+			tile:         "32TQM",
+			orbit:        13456,
+			metadataName: "MTD_TL.xml",
+		},
+		{
+			name:         "S2A_OPER_MSI_L1C_TL_SGS__20160202T123456_A003123_T36PXT_N02.01",
+			level:        "L1C",
+			tile:         "36PXT",
+			orbit:        3123,
+			baseline:     "02.01",
+			metadataName: "S2A_OPER_MTD_L1C_TL_SGS__20160202T123456_A003123_T36PXT.xml",
+		},
+		{
+			name:         "S2A_OPER_MSI_L1C_TL_SGS__20160202T123456_A003123_T36PXT",
+			level:        "L1C",
+			tile:         "36PXT",
+			orbit:        3123,
+			metadataName: "S2A_OPER_MTD_L1C_TL_SGS__20160202T123456_A003123_T36PXT.xml",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := parseGranuleName(test.name)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if g.Compact != test.compact || g.Level != test.level || g.Tile != test.tile || g.AbsoluteOrbit != test.orbit || g.Baseline != test.baseline {
+				t.Errorf("unexpected fields %+v", g)
+			}
+
+			if g.String() != test.name {
+				t.Errorf("formatted as %s", g.String())
+			}
+
+			if g.metadataName() != test.metadataName {
+				t.Errorf("metadata name %s, expected %s", g.metadataName(), test.metadataName)
+			}
+		})
+	}
+}
+
+func TestBandNameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		compact    bool
+		band       string
+		resolution int
+		tile       string
+	}{
+		{name: "T53NMJ_20170105T013442_B02.jp2", compact: true, band: "B02", tile: "53NMJ"},
+		{name: "T53NMJ_20170105T013442_B8A.jp2", compact: true, band: "B8A", tile: "53NMJ"},
+		{name: "T53NMJ_20170105T013442_TCI.jp2", compact: true, band: "TCI", tile: "53NMJ"},
+		{name: "T32TQM_20180102T101234_B02_10m.jp2", compact: true, band: "B02", resolution: 10, tile: "32TQM"},
+		{name: "T32TQM_20180102T101234_TCI_60m.jp2", compact: true, band: "TCI", resolution: 60, tile: "32TQM"},
+		{name: "T32TQM_20180102T101234_SCL_20m.jp2", compact: true, band: "SCL", resolution: 20, tile: "32TQM"},
+		{name: "S2A_OPER_MSI_L1C_TL_SGS__20160202T123456_A003123_T36PXT_B02.jp2", band: "B02", tile: "36PXT"},
+		{name: "S2A_OPER_MSI_L1C_TL_SGS__20160202T123456_A003123_T36PXT_B8A.jp2", band: "B8A", tile: "36PXT"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := parseBandName(test.name)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tile := b.Tile
+
+			if !b.Compact {
+				tile = b.Granule.Tile
+			}
+
+			if b.Compact != test.compact || b.Band != test.band || b.Resolution != test.resolution || tile != test.tile {
+				t.Errorf("unexpected fields %+v", b)
+			}
+
+			if b.String() != test.name {
+				t.Errorf("formatted as %s", b.String())
+			}
+		})
+	}
+}
+
+func TestTilePath(t *testing.T) {
+	tests := []struct {
+		level string
+		tile  string
+		path  string
+	}{
+		{"L1C", "53NMJ", "tiles/53/N/MJ/"},
+		{"L1C", "01CCV", "tiles/1/C/CV/"},
+		{"L1C", "09UXA", "tiles/9/U/XA/"},
+		{"L2A", "32TQM", "L2/tiles/32/T/QM/"},
+		{"L2A", "01CCV", "L2/tiles/1/C/CV/"},
+	}
+
+	for _, test := range tests {
+		if path := tilePath(test.level, test.tile); path != test.path {
+			t.Errorf("tilePath(%s, %s) = %s, expected %s", test.level, test.tile, path, test.path)
+		}
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	tests := []struct {
+		kind string
+		name string
+	}{
+		{kind: "product", name: ""},
+		{kind: "product", name: "S2A_MSIL1C_20170105T013442_N0204_R031_T53NMJ"},
+		{kind: "product", name: "S2A_MSIL1B_20170105T013442_N0204_R031_T53NMJ_20170105T013443"},
+		{kind: "product", name: "S2A_MSIL1C_20171305T013442_N0204_R031_T53NMJ_20170105T013443"},
+		{kind: "product", name: "S2A_MSIL1C_20170105T013442_N0204_R031_T53NMJ_20170105T013443.zip"},
+		{kind: "product", name: "S2A_OPER_PRD_MSIL1C_PDMC_20160202T130020_R094_V20160202T080035"},
+		{kind: "granule", name: ""},
+		{kind: "granule", name: "L1C_T53NMJ_A8040_20170105T013443"},
+		{kind: "granule", name: "L1C_T53NMJ_A008040_20170105T253443"},
+		{kind: "granule", name: "S2A_OPER_MSI_L1C_TL_SGS__20160202T123456_A003123_T36PXT_N0201"},
+		{kind: "band", name: ""},
+		{kind: "band", name: "T53NMJ_20170105T013442_B02.png"},
+		{kind: "band", name: "T53NMJ_20170105T013442_B02_10.jp2"},
+		{kind: "band", name: "T53NMJ_20170132T013442_B02.jp2"},
+		{kind: "band", name: "S2A_OPER_MSI_L1C_TL_SGS__20160202T123456_A003123_T36PXT_N02.01_B02.jp2"},
+	}
+
+	for _, test := range tests {
+		var err error
+
+		switch test.kind {
+		case "product":
+			_, err = parseProductName(test.name)
+		case "granule":
+			_, err = parseGranuleName(test.name)
+		case "band":
+			_, err = parseBandName(test.name)
+		}
+
+		if err == nil {
+			t.Errorf("%s name %q was accepted", test.kind, test.name)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/golang/geo/s2"
+)
+
+// Maximum number of times the simplification tolerance is halved before the original ring is used
+const maxSimplifyAttempts = 8
+
+// Segment of a ring between the vertices at index i and i+1, used for the self-intersection sweep
+type ringSegment struct {
+	i          int
+	minX, maxX float64
+}
+
+// Normalize a polygon ring so that it can be turned into an s2.Loop
+// Closing and duplicate vertices are removed and the ring is oriented counter-clockwise,
+// since s2 treats the area on the left of the ring as its interior.
+// A ring going around a pole, such as the coast of Antarctica, is oriented to enclose the pole of its hemisphere.
+// Rings with fewer than three distinct vertices or intersecting themselves are rejected.
+func normalizeRing(points []Point) ([]Point, error) {
+	ring := make([]Point, 0, len(points))
+
+	for _, p := range points {
+		if len(ring) > 0 && ring[len(ring)-1] == p {
+			continue
+		}
+		ring = append(ring, p)
+	}
+
+	// Close the ring implicitly
+	for len(ring) > 1 && ring[len(ring)-1] == ring[0] {
+		ring = ring[:len(ring)-1]
+	}
+
+	if len(ring) < 3 {
+		return nil, fmt.Errorf("Polygon must have at least 3 distinct vertices, got %d", len(ring))
+	}
+
+	planar := unwrapRing(ring)
+	winding := ringWinding(ring, planar)
+
+	// A ring which does not intersect itself goes around a pole at most once
+	if winding > 1 || winding < -1 {
+		return nil, fmt.Errorf("Polygon intersects itself, it goes around a pole %d times", int(math.Abs(float64(winding))))
+	}
+
+	if i, j, ok := findRingIntersection(planar, winding); ok {
+		return nil, fmt.Errorf("Polygon intersects itself between vertices %d and %d", i, j)
+	}
+
+	if !isCounterClockwise(ring, planar, winding) {
+		for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+			ring[i], ring[j] = ring[j], ring[i]
+		}
+	}
+
+	return ring, nil
+}
+
+// Get the number of times a ring goes around the poles, positive when it runs eastwards
+// Rings not enclosing a pole have a winding of 0.
+func ringWinding(ring []Point, planar [][2]float64) int {
+	last := len(ring) - 1
+
+	// The closing edge is not part of the unwrapped ring
+	closing := ring[0].lng - ring[last].lng
+
+	if closing > 180 {
+		closing -= 360
+	} else if closing < -180 {
+		closing += 360
+	}
+
+	return int(math.Round((planar[last][0] - planar[0][0] + closing) / 360))
+}
+
+// Check whether a ring is oriented counter-clockwise
+// A ring around a pole is counter-clockwise when the pole of the hemisphere holding most of its vertices
+// lies on its left, that is when it runs eastwards around the north pole or westwards around the south pole.
+func isCounterClockwise(ring []Point, planar [][2]float64, winding int) bool {
+	if winding == 0 {
+		return signedArea(planar) > 0
+	}
+
+	lat := 0.0
+
+	for _, p := range ring {
+		lat += p.lat
+	}
+
+	return (lat >= 0) == (winding > 0)
+}
+
+// Find two non-adjacent edges of a planar ring that intersect each other, given the winding of the ring
+// The unwrapped vertices of a ring around a pole do not close, so the ring is repeated to the west and east,
+// which also catches edges meeting across the start of the ring.
+func findRingIntersection(ring [][2]float64, winding int) (int, int, bool) {
+	if winding == 0 {
+		return findSelfIntersection(ring)
+	}
+
+	n := len(ring)
+	shift := float64(winding) * 360
+
+	line := make([][2]float64, 0, 3*n+1)
+
+	for k := -1.0; k <= 1; k++ {
+		for _, p := range ring {
+			line = append(line, [2]float64{p[0] + k*shift, p[1]})
+		}
+	}
+
+	line = append(line, [2]float64{ring[0][0] + 2*shift, ring[0][1]})
+
+	i, j, ok := findCrossing(line, len(line)-1)
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	i, j = i%n, j%n
+
+	if i > j {
+		i, j = j, i
+	}
+
+	return i, j, true
+}
+
+// Get planar (x = longitude, y = latitude) coordinates of a ring
+// Longitudes are unwrapped so that a ring crossing the antimeridian stays continuous
+func unwrapRing(ring []Point) [][2]float64 {
+	planar := make([][2]float64, len(ring))
+
+	offset := 0.0
+
+	for i, p := range ring {
+		if i > 0 {
+			delta := p.lng - ring[i-1].lng
+
+			if delta > 180 {
+				offset -= 360
+			} else if delta < -180 {
+				offset += 360
+			}
+		}
+		planar[i] = [2]float64{p.lng + offset, p.lat}
+	}
+
+	return planar
+}
+
+// Get the signed area of a planar ring using the shoelace formula, positive for counter-clockwise rings
+func signedArea(ring [][2]float64) float64 {
+	area := 0.0
+
+	for i := range ring {
+		a, b := ring[i], ring[(i+1)%len(ring)]
+		area += a[0]*b[1] - b[0]*a[1]
+	}
+
+	return area / 2
+}
+
+// Find two non-adjacent edges of a planar ring that intersect each other
+func findSelfIntersection(ring [][2]float64) (int, int, bool) {
+	return findCrossing(ring, len(ring))
+}
+
+// Find two non-adjacent edges among the first edges of a planar ring that intersect each other
+// A line is checked by leaving out the closing edge.
+// Edges are swept from west to east so that only edges overlapping in longitude are compared.
+func findCrossing(ring [][2]float64, edges int) (int, int, bool) {
+	n := len(ring)
+
+	segments := make([]ringSegment, edges)
+
+	for i := range segments {
+		a, b := ring[i], ring[(i+1)%n]
+		segments[i] = ringSegment{i: i, minX: math.Min(a[0], b[0]), maxX: math.Max(a[0], b[0])}
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].minX < segments[j].minX
+	})
+
+	active := make([]ringSegment, 0)
+
+	for _, s := range segments {
+		// Drop all edges which end before the current edge starts
+		kept := active[:0]
+		for _, a := range active {
+			if a.maxX >= s.minX {
+				kept = append(kept, a)
+			}
+		}
+		active = kept
+
+		for _, a := range active {
+			i, j := a.i, s.i
+
+			// Adjacent edges always share a vertex
+			if (i+1)%n == j || (j+1)%n == i {
+				continue
+			}
+
+			if segmentsIntersect(ring[i], ring[(i+1)%n], ring[j], ring[(j+1)%n]) {
+				if i > j {
+					i, j = j, i
+				}
+				return i, j, true
+			}
+		}
+
+		active = append(active, s)
+	}
+
+	return 0, 0, false
+}
+
+// Check whether the planar segments ab and cd intersect or touch
+func segmentsIntersect(a, b, c, d [2]float64) bool {
+	d1 := orientation(c, d, a)
+	d2 := orientation(c, d, b)
+	d3 := orientation(a, b, c)
+	d4 := orientation(a, b, d)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+
+	return (d1 == 0 && onSegment(c, d, a)) ||
+		(d2 == 0 && onSegment(c, d, b)) ||
+		(d3 == 0 && onSegment(a, b, c)) ||
+		(d4 == 0 && onSegment(a, b, d))
+}
+
+// Get the orientation of point c relative to the line ab, positive when c lies on the left
+func orientation(a, b, c [2]float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+// Check whether the point c, collinear with ab, lies within the segment ab
+func onSegment(a, b, c [2]float64) bool {
+	return math.Min(a[0], b[0]) <= c[0] && c[0] <= math.Max(a[0], b[0]) &&
+		math.Min(a[1], b[1]) <= c[1] && c[1] <= math.Max(a[1], b[1])
+}
+
+// Simplify a normalized ring before covering it
+// Vertex detail below the tolerance given by the covering parameters cannot be represented by the covering,
+// so it is removed using the Douglas-Peucker algorithm. Whenever the simplified ring intersects itself
+// or flips its orientation the tolerance is halved, the original ring is returned as a last resort.
+func simplifyRing(ring []Point, opts CoveringOptions) []Point {
+	planar := unwrapRing(ring)
+	winding := ringWinding(ring, planar)
+	tolerance := simplificationTolerance(ringArea(planar, winding), opts)
+
+	for attempt := 0; attempt < maxSimplifyAttempts; attempt++ {
+		keep := douglasPeucker(planar, tolerance)
+
+		if len(keep) >= 3 && len(keep) < len(ring) {
+			simplified := make([]Point, 0, len(keep))
+			simplifiedPlanar := make([][2]float64, 0, len(keep))
+
+			for _, i := range keep {
+				simplified = append(simplified, ring[i])
+				simplifiedPlanar = append(simplifiedPlanar, planar[i])
+			}
+
+			_, _, intersects := findRingIntersection(simplifiedPlanar, winding)
+
+			if !intersects && ringWinding(simplified, simplifiedPlanar) == winding &&
+				isCounterClockwise(simplified, simplifiedPlanar, winding) {
+				return simplified
+			}
+		}
+
+		tolerance /= 2
+	}
+
+	return ring
+}
+
+// Normalize and simplify the rings of a polygon
+// Holes are rings nested within other rings, all rings are oriented counter-clockwise like in s2.
+func simplifyRings(rings [][]Point, opts CoveringOptions) ([][]Point, error) {
+	simplified := make([][]Point, 0, len(rings))
+
+	for i, points := range rings {
+		ring, err := normalizeRing(points)
+
+		if err != nil && len(rings) > 1 {
+			return nil, fmt.Errorf("Ring %d: %s", i+1, err.Error())
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		simplified = append(simplified, simplifyRing(ring, opts))
+	}
+
+	return simplified, nil
+}
+
+// Get the planar area of a ring in square degrees, a ring around a pole is closed along the nearer pole
+func ringArea(ring [][2]float64, winding int) float64 {
+	if winding == 0 {
+		return math.Abs(signedArea(ring))
+	}
+
+	first := ring[0]
+	lat := 0.0
+
+	for _, p := range ring {
+		lat += p[1]
+	}
+
+	pole := math.Copysign(90, lat)
+
+	closed := append(append([][2]float64{}, ring...),
+		[2]float64{first[0] + float64(winding)*360, first[1]},
+		[2]float64{first[0] + float64(winding)*360, pole},
+		[2]float64{first[0], pole})
+
+	return math.Abs(signedArea(closed))
+}
+
+// Get the simplification tolerance in degrees tied to the covering level
+// The coverer cannot afford cells much smaller than the area of the ring divided among MaxCells,
+// the tolerance is half the width of cells two levels below that (and never below MaxLevel).
+func simplificationTolerance(area float64, opts CoveringOptions) float64 {
+	area *= (math.Pi / 180) * (math.Pi / 180)
+
+	level := s2.AvgAreaMetric.ClosestLevel(area/float64(opts.MaxCells)) + 2
+
+	if level > opts.MaxLevel {
+		level = opts.MaxLevel
+	}
+
+	return s2.MinWidthMetric.Value(level) * 180 / math.Pi / 2
+}
+
+// Get the indices of the vertices of a planar ring kept by the Douglas-Peucker algorithm
+// The ring is split at the vertex farthest from the first one and both halves are simplified separately.
+func douglasPeucker(ring [][2]float64, tolerance float64) []int {
+	n := len(ring)
+
+	if n < 4 {
+		indices := make([]int, n)
+		for i := range indices {
+			indices[i] = i
+		}
+		return indices
+	}
+
+	// Index n refers to the first vertex again, closing the ring
+	at := func(i int) [2]float64 {
+		return ring[i%n]
+	}
+
+	far, farDist := 0, -1.0
+
+	for i := 1; i < n; i++ {
+		d := math.Hypot(ring[i][0]-ring[0][0], ring[i][1]-ring[0][1])
+		if d > farDist {
+			far, farDist = i, d
+		}
+	}
+
+	keep := make([]bool, n+1)
+	keep[0], keep[far], keep[n] = true, true, true
+
+	stack := [][2]int{{0, far}, {far, n}}
+
+	for len(stack) > 0 {
+		span := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		first, last := span[0], span[1]
+		index, maxDist := -1, tolerance
+
+		for i := first + 1; i < last; i++ {
+			d := segmentDistance(at(first), at(last), at(i))
+			if d > maxDist {
+				index, maxDist = i, d
+			}
+		}
+
+		if index >= 0 {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+
+	indices := make([]int, 0)
+
+	for i := 0; i < n; i++ {
+		if keep[i] {
+			indices = append(indices, i)
+		}
+	}
+
+	return indices
+}
+
+// Get the planar distance of point c from the segment ab
+func segmentDistance(a, b, c [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	length := dx*dx + dy*dy
+
+	if length == 0 {
+		return math.Hypot(c[0]-a[0], c[1]-a[1])
+	}
+
+	t := ((c[0]-a[0])*dx + (c[1]-a[1])*dy) / length
+	t = math.Max(0, math.Min(1, t))
+
+	return math.Hypot(c[0]-(a[0]+t*dx), c[1]-(a[1]+t*dy))
+}
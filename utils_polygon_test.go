@@ -0,0 +1,303 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+// Get a ring of n vertices on a circle, counter-clockwise unless the step is negative
+func circleRing(lat, lng, radius float64, n int, step float64) []Point {
+	ring := make([]Point, n)
+
+	for i := range ring {
+		angle := step * 2 * math.Pi * float64(i) / float64(n)
+		ring[i] = Point{lat: lat + radius*math.Sin(angle), lng: lng + radius*math.Cos(angle)}
+	}
+
+	return ring
+}
+
+// Get a ring of vertices at a latitude going around a pole through the given longitudes
+func parallelRing(lat float64, lngs ...float64) []Point {
+	ring := make([]Point, len(lngs))
+
+	for i, lng := range lngs {
+		ring[i] = Point{lat: lat, lng: lng}
+	}
+
+	return ring
+}
+
+// Get a ring of n vertices going eastwards around a pole, wavering slightly around a latitude
+func wavyParallel(lat float64, n int) []Point {
+	ring := make([]Point, n)
+
+	for i := range ring {
+		ring[i] = Point{lat: lat + math.Sin(float64(i)/20)/100, lng: -180 + 360*float64(i)/float64(n)}
+	}
+
+	return ring
+}
+
+func TestUnwrapRing(t *testing.T) {
+	tests := []struct {
+		name string
+		ring []Point
+		lngs []float64
+	}{
+		{
+			name: "greenwich",
+			ring: []Point{{lat: 0, lng: -1}, {lat: 0, lng: 1}, {lat: 1, lng: 1}},
+			lngs: []float64{-1, 1, 1},
+		},
+		{
+			name: "antimeridian eastwards",
+			ring: []Point{{lat: 0, lng: 179}, {lat: 0, lng: -179}, {lat: 1, lng: -179}, {lat: 1, lng: 179}},
+			lngs: []float64{179, 181, 181, 179},
+		},
+		{
+			name: "antimeridian westwards",
+			ring: []Point{{lat: 0, lng: -179}, {lat: 0, lng: 179}, {lat: 1, lng: 179}, {lat: 1, lng: -179}},
+			lngs: []float64{-179, -181, -181, -179},
+		},
+		{
+			name: "around the pole",
+			ring: parallelRing(70, 0, 120, -120, 0, 120),
+			lngs: []float64{0, 120, 240, 360, 480},
+		},
+	}
+
+	for _, test := range tests {
+		planar := unwrapRing(test.ring)
+
+		for i, p := range planar {
+			if p[0] != test.lngs[i] || p[1] != test.ring[i].lat {
+				t.Errorf("%s: vertex %d unwrapped to %v, expected longitude %g", test.name, i, p, test.lngs[i])
+			}
+		}
+	}
+}
+
+func TestFindSelfIntersection(t *testing.T) {
+	tests := []struct {
+		name       string
+		ring       [][2]float64
+		intersects bool
+	}{
+		{name: "square", ring: [][2]float64{{0, 0}, {1, 0}, {1, 1}, {0, 1}}},
+		{name: "concave", ring: [][2]float64{{0, 0}, {2, 0}, {2, 2}, {1, 1}, {0, 2}}},
+		{name: "bow tie", ring: [][2]float64{{0, 0}, {1, 1}, {1, 0}, {0, 1}}, intersects: true},
+		{name: "closing edge", ring: [][2]float64{{0, 0}, {2, 0}, {2, 2}, {1, -1}}, intersects: true},
+		{name: "touching vertex", ring: [][2]float64{{0, 0}, {2, 0}, {1, 0}, {1, 1}}, intersects: true},
+		{name: "collinear overlap", ring: [][2]float64{{0, 0}, {3, 0}, {3, 1}, {2, 0}, {1, 1}}, intersects: true},
+	}
+
+	for _, test := range tests {
+		if _, _, ok := findSelfIntersection(test.ring); ok != test.intersects {
+			t.Errorf("%s: intersection %t, expected %t", test.name, ok, test.intersects)
+		}
+	}
+}
+
+func TestNormalizeRing(t *testing.T) {
+	northPole := s2.PointFromLatLng(s2.LatLngFromDegrees(90, 0))
+	southPole := s2.PointFromLatLng(s2.LatLngFromDegrees(-90, 0))
+
+	tests := []struct {
+		name     string
+		ring     []Point
+		vertices int
+		reversed bool
+		// Points inside and outside of the normalized ring
+		inside  []s2.Point
+		outside []s2.Point
+	}{
+		{
+			name:     "counter-clockwise",
+			ring:     []Point{{lat: 0, lng: 0}, {lat: 0, lng: 1}, {lat: 1, lng: 1}, {lat: 1, lng: 0}},
+			vertices: 4,
+			inside:   []s2.Point{s2.PointFromLatLng(s2.LatLngFromDegrees(0.5, 0.5))},
+			outside:  []s2.Point{northPole, southPole},
+		},
+		{
+			name:     "clockwise",
+			ring:     []Point{{lat: 0, lng: 0}, {lat: 1, lng: 0}, {lat: 1, lng: 1}, {lat: 0, lng: 1}},
+			vertices: 4,
+			reversed: true,
+			inside:   []s2.Point{s2.PointFromLatLng(s2.LatLngFromDegrees(0.5, 0.5))},
+			outside:  []s2.Point{northPole, southPole},
+		},
+		{
+			name:     "closed with duplicates",
+			ring:     []Point{{lat: 0, lng: 0}, {lat: 0, lng: 1}, {lat: 0, lng: 1}, {lat: 1, lng: 1}, {lat: 1, lng: 0}, {lat: 0, lng: 0}},
+			vertices: 4,
+			inside:   []s2.Point{s2.PointFromLatLng(s2.LatLngFromDegrees(0.5, 0.5))},
+		},
+		{
+			name:     "clockwise across the antimeridian",
+			ring:     []Point{{lat: 0, lng: 179}, {lat: 1, lng: 179}, {lat: 1, lng: -179}, {lat: 0, lng: -179}},
+			vertices: 4,
+			reversed: true,
+			inside:   []s2.Point{s2.PointFromLatLng(s2.LatLngFromDegrees(0.5, 180))},
+			outside:  []s2.Point{s2.PointFromLatLng(s2.LatLngFromDegrees(0.5, 0))},
+		},
+		{
+			name:     "eastwards around the north pole",
+			ring:     parallelRing(70, 0, 90, 180, -90),
+			vertices: 4,
+			inside:   []s2.Point{northPole},
+			outside:  []s2.Point{southPole},
+		},
+		{
+			name:     "westwards around the north pole",
+			ring:     parallelRing(70, 0, -90, 180, 90),
+			vertices: 4,
+			reversed: true,
+			inside:   []s2.Point{northPole},
+			outside:  []s2.Point{southPole},
+		},
+		{
+			name:     "eastwards around the south pole",
+			ring:     parallelRing(-70, 0, 90, 180, -90),
+			vertices: 4,
+			reversed: true,
+			inside:   []s2.Point{southPole},
+			outside:  []s2.Point{northPole},
+		},
+		{
+			name:     "westwards around the south pole",
+			ring:     parallelRing(-70, 0, -90, 180, 90),
+			vertices: 4,
+			inside:   []s2.Point{southPole},
+			outside:  []s2.Point{northPole},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ring, err := normalizeRing(test.ring)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ring) != test.vertices {
+				t.Errorf("%d vertices, expected %d", len(ring), test.vertices)
+			}
+
+			if reversed := ring[0] != test.ring[0]; reversed != test.reversed {
+				t.Errorf("reversed %t, expected %t", reversed, test.reversed)
+			}
+
+			polygon := polygonFromPoints(ring)
+
+			for _, p := range test.inside {
+				if !polygon.ContainsPoint(p) {
+					t.Errorf("%v is not inside", s2.LatLngFromPoint(p))
+				}
+			}
+
+			for _, p := range test.outside {
+				if polygon.ContainsPoint(p) {
+					t.Errorf("%v is not outside", s2.LatLngFromPoint(p))
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeRingRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		ring []Point
+	}{
+		{name: "empty"},
+		{name: "two vertices", ring: []Point{{lat: 0, lng: 0}, {lat: 1, lng: 1}, {lat: 0, lng: 0}}},
+		{name: "repeated vertices", ring: []Point{{lat: 0, lng: 0}, {lat: 0, lng: 0}, {lat: 1, lng: 1}, {lat: 1, lng: 1}}},
+		{name: "bow tie", ring: []Point{{lat: 0, lng: 0}, {lat: 1, lng: 1}, {lat: 0, lng: 1}, {lat: 1, lng: 0}}},
+		{name: "bow tie across the antimeridian", ring: []Point{{lat: 0, lng: 179}, {lat: 1, lng: -179}, {lat: 0, lng: -179}, {lat: 1, lng: 179}}},
+		{name: "twice around the pole", ring: append(parallelRing(70, 0, 120, -120), parallelRing(75, 0, 120, -120)...)},
+	}
+
+	for _, test := range tests {
+		if ring, err := normalizeRing(test.ring); err == nil {
+			t.Errorf("%s: normalized to %v", test.name, ring)
+		}
+	}
+}
+
+func TestSimplifyRing(t *testing.T) {
+	opts := CoveringOptions{MinLevel: 0, MaxLevel: s2.MaxLevel, MaxCells: 8}
+
+	tests := []struct {
+		name string
+		ring []Point
+	}{
+		{name: "circle", ring: circleRing(45, 10, 1, 2000, 1)},
+		{name: "circle across the antimeridian", ring: circleRing(-20, 180, 1, 2000, 1)},
+		{name: "around the north pole", ring: wavyParallel(80, 3600)},
+		{name: "around the south pole", ring: wavyParallel(-80, 3600)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ring, err := normalizeRing(test.ring)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			simplified := simplifyRing(ring, opts)
+
+			if len(simplified) >= len(ring) || len(simplified) < 3 {
+				t.Fatalf("simplified from %d to %d vertices", len(ring), len(simplified))
+			}
+
+			planar := unwrapRing(simplified)
+			winding := ringWinding(simplified, planar)
+
+			if winding != ringWinding(ring, unwrapRing(ring)) {
+				t.Errorf("winding changed to %d", winding)
+			}
+
+			if _, _, ok := findRingIntersection(planar, winding); ok {
+				t.Error("simplified ring intersects itself")
+			}
+
+			if !isCounterClockwise(simplified, planar, winding) {
+				t.Error("simplified ring is not counter-clockwise")
+			}
+		})
+	}
+}
+
+func TestSimplifyRings(t *testing.T) {
+	opts := DefaultCoveringOptions
+
+	outer := circleRing(0, 0, 2, 100, 1)
+	hole := circleRing(0, 0, 1, 100, -1)
+
+	rings, err := simplifyRings([][]Point{outer, hole}, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	polygon := polygonFromRings(rings)
+
+	if polygon.ContainsPoint(s2.PointFromLatLng(s2.LatLngFromDegrees(0, 0))) {
+		t.Error("the hole is part of the polygon")
+	}
+
+	if !polygon.ContainsPoint(s2.PointFromLatLng(s2.LatLngFromDegrees(0, 1.5))) {
+		t.Error("the area between the rings is not part of the polygon")
+	}
+
+	bowTie := []Point{{lat: 0, lng: 0}, {lat: 1, lng: 1}, {lat: 0, lng: 1}, {lat: 1, lng: 0}}
+
+	if _, err := simplifyRings([][]Point{outer, bowTie}, opts); err == nil || err.Error()[:7] != "Ring 2:" {
+		t.Errorf("invalid second ring reported as %v", err)
+	}
+}
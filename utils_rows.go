@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// Types of the columns of the Sentinel-2 index used by the service
+var indexSchema = map[string]bigquery.FieldType{
+	"base_url":   bigquery.StringFieldType,
+	"granule_id": bigquery.StringFieldType,
+	"south_lat":  bigquery.FloatFieldType,
+	"west_lon":   bigquery.FloatFieldType,
+	"north_lat":  bigquery.FloatFieldType,
+	"east_lon":   bigquery.FloatFieldType,
+
+	"product_id":          bigquery.StringFieldType,
+	"datatake_identifier": bigquery.StringFieldType,
+	"mgrs_tile":           bigquery.StringFieldType,
+	"sensing_time":        bigquery.TimestampFieldType,
+	"generation_time":     bigquery.TimestampFieldType,
+	"cloud_cover":         bigquery.FloatFieldType,
+	"total_size":          bigquery.IntegerFieldType,
+}
+
+// Columns selected by granule queries
+var granuleColumns = []string{"base_url", "granule_id", "south_lat", "west_lon", "north_lat", "east_lon", "sensing_time"}
+
+// Row of the Sentinel-2 index
+// All columns are nullable, so that a null value only invalidates its own row instead of the whole query.
+type granuleRow struct {
+	BaseURL     bigquery.NullString    `bigquery:"base_url"`
+	GranuleID   bigquery.NullString    `bigquery:"granule_id"`
+	SouthLat    bigquery.NullFloat64   `bigquery:"south_lat"`
+	WestLon     bigquery.NullFloat64   `bigquery:"west_lon"`
+	NorthLat    bigquery.NullFloat64   `bigquery:"north_lat"`
+	EastLon     bigquery.NullFloat64   `bigquery:"east_lon"`
+	SensingTime bigquery.NullTimestamp `bigquery:"sensing_time"`
+}
+
+// Convert a row selecting all granule columns to a Granule
+func (row granuleRow) granule() (Granule, error) {
+	if !row.BaseURL.Valid || !row.GranuleID.Valid {
+		return Granule{}, fmt.Errorf("index row without base_url or granule_id")
+	}
+
+	if !row.SouthLat.Valid || !row.WestLon.Valid || !row.NorthLat.Valid || !row.EastLon.Valid {
+		return Granule{}, fmt.Errorf("index row of granule %s without bounds", row.GranuleID.StringVal)
+	}
+
+	product, err := parseProductName(path.Base(row.BaseURL.StringVal))
+
+	if err != nil {
+		return Granule{}, fmt.Errorf("index row of granule %s: %s", row.GranuleID.StringVal, err.Error())
+	}
+
+	name, err := parseGranuleName(row.GranuleID.StringVal)
+
+	if err != nil {
+		return Granule{}, fmt.Errorf("index row: %s", err.Error())
+	}
+
+	return Granule{
+		BaseURL:   row.BaseURL.StringVal,
+		GranuleID: row.GranuleID.StringVal,
+		Bounds: Bounds{
+			South: row.SouthLat.Float64,
+			West:  row.WestLon.Float64,
+			North: row.NorthLat.Float64,
+			East:  row.EastLon.Float64,
+		},
+		// A null sensing time is left zero
+		SensingTime: row.SensingTime.Timestamp,
+		Product:     product,
+		Name:        name,
+	}, nil
+}
+
+// Columns selected for the index entry of a single granule
+var indexColumns = append([]string{"product_id", "datatake_identifier", "mgrs_tile", "generation_time", "cloud_cover", "total_size"}, granuleColumns...)
+
+// Row of the Sentinel-2 index with all columns of an index entry
+type indexRow struct {
+	granuleRow
+	ProductID          bigquery.NullString    `bigquery:"product_id"`
+	DatatakeIdentifier bigquery.NullString    `bigquery:"datatake_identifier"`
+	MGRSTile           bigquery.NullString    `bigquery:"mgrs_tile"`
+	GenerationTime     bigquery.NullTimestamp `bigquery:"generation_time"`
+	CloudCover         bigquery.NullFloat64   `bigquery:"cloud_cover"`
+	TotalSize          bigquery.NullInt64     `bigquery:"total_size"`
+}
+
+// IndexEntry holds all columns of the Sentinel-2 index for a single granule
+type IndexEntry struct {
+	GranuleID          string    `json:"granule_id"`
+	ProductID          string    `json:"product_id"`
+	DatatakeIdentifier string    `json:"datatake_identifier"`
+	MGRSTile           string    `json:"mgrs_tile"`
+	SensingTime        time.Time `json:"sensing_time"`
+	GenerationTime     time.Time `json:"generation_time"`
+	// Cloud cover of the whole granule in percent, nil when unknown
+	CloudCover *float64 `json:"cloud_cover"`
+	TotalSize  int64    `json:"total_size"`
+	BaseURL    string   `json:"base_url"`
+	Bounds     Bounds   `json:"bounds"`
+}
+
+// Convert a row selecting all index columns to an IndexEntry
+// Only the granule columns are required, the other columns are left empty when they are null.
+func (row indexRow) entry() (Granule, IndexEntry, error) {
+	granule, err := row.granule()
+
+	if err != nil {
+		return Granule{}, IndexEntry{}, err
+	}
+
+	entry := IndexEntry{
+		GranuleID:          granule.GranuleID,
+		ProductID:          row.ProductID.StringVal,
+		DatatakeIdentifier: row.DatatakeIdentifier.StringVal,
+		MGRSTile:           row.MGRSTile.StringVal,
+		SensingTime:        granule.SensingTime,
+		GenerationTime:     row.GenerationTime.Timestamp,
+		TotalSize:          row.TotalSize.Int64,
+		BaseURL:            granule.BaseURL,
+		Bounds:             granule.Bounds,
+	}
+
+	if row.CloudCover.Valid {
+		cloudCover := row.CloudCover.Float64
+		entry.CloudCover = &cloudCover
+	}
+
+	return granule, entry, nil
+}
+
+// Check that a query result has all selected columns of the index with the expected types
+func validateSchema(schema bigquery.Schema, columns []string) error {
+	fields := make(map[string]*bigquery.FieldSchema, len(schema))
+
+	for _, field := range schema {
+		fields[field.Name] = field
+	}
+
+	for _, column := range columns {
+		field, ok := fields[column]
+
+		if !ok {
+			return fmt.Errorf("index schema: missing column %s", column)
+		}
+
+		if field.Type != indexSchema[column] || field.Repeated {
+			return fmt.Errorf("index schema: column %s has type %s, expected %s", column, field.Type, indexSchema[column])
+		}
+	}
+
+	return nil
+}
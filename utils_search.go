@@ -0,0 +1,493 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// PixelResolution is the resolution in meters at which pixel positions of point searches are given
+const PixelResolution = 10
+
+// SearchOptions controls which granules a search returns and how they are described
+type SearchOptions struct {
+	// Return a result per granule including its tile metadata instead of a flat list of images
+	Metadata bool
+	// Return the granules of all processing baselines instead of the newest one of every acquisition
+	AllBaselines bool
+	// Filters on the viewing geometry of the tiles in degrees, NaN when not set
+	MinSunElevation float64
+	MaxViewZenith   float64
+	// Range of the sun azimuth, the range wraps through north when the minimum is greater than the maximum
+	MinSunAzimuth float64
+	MaxSunAzimuth float64
+	// Maximum cloud cover of the AOI in percent, NaN when not set
+	MaxAOICloud float64
+	// Minimum part of the AOI with valid data in percent, NaN when not set
+	MinCoverage float64
+	// Order of the results, "aoi_cloud" or empty for the order of the search
+	Sort string
+	// Area of interest of the search, set by the handler
+	AOI s2.CellUnion
+}
+
+// Orders supported by searches
+var searchOrders = map[string]bool{"": true, "aoi_cloud": true}
+
+// Parse the search options of a request
+func parseSearchOptions(r *http.Request) (SearchOptions, error) {
+	opts := SearchOptions{Metadata: r.FormValue("metadata") == "true", AllBaselines: r.FormValue("all_baselines") == "true"}
+
+	var err error
+
+	if opts.MinSunElevation, err = parseAngle(r, "min_sun_elevation", -90, 90); err != nil {
+		return opts, err
+	}
+
+	if opts.MaxViewZenith, err = parseAngle(r, "max_view_zenith", 0, 90); err != nil {
+		return opts, err
+	}
+
+	if opts.MinSunAzimuth, err = parseAngle(r, "min_sun_azimuth", 0, 360); err != nil {
+		return opts, err
+	}
+
+	if opts.MaxSunAzimuth, err = parseAngle(r, "max_sun_azimuth", 0, 360); err != nil {
+		return opts, err
+	}
+
+	opts.MaxAOICloud = math.NaN()
+
+	if value := r.FormValue("max_aoi_cloud"); value != "" {
+		opts.MaxAOICloud, err = strconv.ParseFloat(value, 64)
+
+		if err != nil || !(opts.MaxAOICloud >= 0 && opts.MaxAOICloud <= 100) {
+			return opts, errors.New("Parameter 'max_aoi_cloud' must be a percentage between 0 and 100")
+		}
+	}
+
+	opts.MinCoverage = math.NaN()
+
+	if value := r.FormValue("min_coverage"); value != "" {
+		opts.MinCoverage, err = strconv.ParseFloat(value, 64)
+
+		if err != nil || !(opts.MinCoverage >= 0 && opts.MinCoverage <= 100) {
+			return opts, errors.New("Parameter 'min_coverage' must be a percentage between 0 and 100")
+		}
+	}
+
+	opts.Sort = r.FormValue("sort")
+
+	if !searchOrders[opts.Sort] {
+		return opts, errors.New("Parameter 'sort' must be 'aoi_cloud'")
+	}
+
+	return opts, nil
+}
+
+// Parse an optional angle in degrees within a range, NaN is returned when the parameter is not set
+func parseAngle(r *http.Request, name string, min, max float64) (float64, error) {
+	value := r.FormValue(name)
+
+	if value == "" {
+		return math.NaN(), nil
+	}
+
+	angle, err := strconv.ParseFloat(value, 64)
+
+	if err != nil || !(angle >= min && angle <= max) {
+		return 0, fmt.Errorf("Parameter '%s' must be a number of degrees between %.0f and %.0f", name, min, max)
+	}
+
+	return angle, nil
+}
+
+// Check whether the search filters granules by their tile metadata
+func (opts SearchOptions) filtersTiles() bool {
+	return !math.IsNaN(opts.MinSunElevation) || !math.IsNaN(opts.MaxViewZenith) ||
+		!math.IsNaN(opts.MinSunAzimuth) || !math.IsNaN(opts.MaxSunAzimuth)
+}
+
+// Check whether a tile matches all filters of the search
+func (opts SearchOptions) matchesTile(tile *TileMetadata) bool {
+	// The sun elevation is the complement of the zenith angle
+	if !math.IsNaN(opts.MinSunElevation) && 90-tile.SunZenith < opts.MinSunElevation {
+		return false
+	}
+
+	if !math.IsNaN(opts.MaxViewZenith) && tile.maxViewZenith() > opts.MaxViewZenith {
+		return false
+	}
+
+	min, max := opts.MinSunAzimuth, opts.MaxSunAzimuth
+
+	if math.IsNaN(min) {
+		min = 0
+	}
+
+	if math.IsNaN(max) {
+		max = 360
+	}
+
+	if min <= max {
+		return tile.SunAzimuth >= min && tile.SunAzimuth <= max
+	}
+
+	return tile.SunAzimuth >= min || tile.SunAzimuth <= max
+}
+
+// Check whether the search needs the cloud cover of the AOI
+func (opts SearchOptions) filtersClouds() bool {
+	return !math.IsNaN(opts.MaxAOICloud) || opts.Sort == "aoi_cloud"
+}
+
+// Check whether the search needs the part of the AOI with valid data
+func (opts SearchOptions) filtersCoverage() bool {
+	return !math.IsNaN(opts.MinCoverage)
+}
+
+// Check whether the search returns the part of the AOI with valid data
+// It is returned along with the metadata of every granule whenever the search has an AOI, and when filtering by it.
+func (opts SearchOptions) computesCoverage() bool {
+	return opts.filtersCoverage() || (opts.Metadata && len(opts.AOI) > 0)
+}
+
+// Check whether the search depends on the AOI
+func (opts SearchOptions) needsAOI() bool {
+	return opts.filtersClouds() || opts.filtersCoverage()
+}
+
+// GranuleImages holds the images of a single granule found by a search
+type GranuleImages struct {
+	GranuleID string        `json:"granule_id"`
+	Bounds    Bounds        `json:"bounds"`
+	Images    []string      `json:"images"`
+	Tile      *TileMetadata `json:"tile,omitempty"`
+	// Cloud cover of the part of the AOI within the granule in percent, only set when filtering or sorting by it
+	AOICloud *float64 `json:"aoi_cloud,omitempty"`
+	// Source of the cloud cover, "mask" when computed from the vector cloud mask for the AOI,
+	// "tile" when it is the cloudy pixel percentage of the whole tile for granules without a vector cloud mask
+	AOICloudSource string `json:"aoi_cloud_source,omitempty"`
+	// Part of the AOI within the granule with valid data in percent, set with the metadata or when filtering by it
+	Coverage *float64 `json:"coverage,omitempty"`
+	// Pixel containing the searched coordinate, only set for point searches
+	Pixel *PixelPosition `json:"pixel,omitempty"`
+	// Checksums of the images listed in the manifest of the product by file name, only set with the metadata
+	Checksums map[string]FileChecksum `json:"checksums,omitempty"`
+
+	granule Granule
+}
+
+// Get the images of all granules matching the filters of a search, along with their tile metadata when requested
+// The metadata and cloud masks are only fetched for the granules found by the index, and only when they are needed.
+func (s *Server) searchGranules(granules []Granule, opts SearchOptions) ([]GranuleImages, error) {
+	if !opts.AllBaselines {
+		granules = latestBaselines(granules)
+	}
+
+	results := make([]GranuleImages, len(granules))
+
+	for i, g := range granules {
+		results[i] = GranuleImages{GranuleID: g.GranuleID, Bounds: g.Bounds, granule: g}
+	}
+
+	if opts.Metadata || opts.filtersTiles() {
+		tiles, err := s.getTiles(granules)
+
+		if err != nil {
+			return nil, err
+		}
+
+		matching := results[:0]
+
+		for i, res := range results {
+			if !opts.matchesTile(tiles[i]) {
+				continue
+			}
+
+			if opts.Metadata {
+				res.Tile = tiles[i]
+			}
+
+			matching = append(matching, res)
+		}
+
+		results = matching
+	}
+
+	// Granules without data for the AOI are dropped before their cloud masks are fetched
+	if opts.computesCoverage() {
+		var err error
+
+		results, err = s.filterCoverage(results, opts)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.filtersClouds() {
+		var err error
+
+		results, err = s.filterClouds(results, opts)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	images, objects, err := s.listGranuleImages(resultGranules(results))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Images = images[i]
+	}
+
+	if opts.Metadata {
+		if err := s.setChecksums(results, objects); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// Set the manifest checksums of the images of all results, given the object names of the images
+func (s *Server) setChecksums(results []GranuleImages, objects [][]string) error {
+	manifests, err := s.performGranules(resultGranules(results), Granule.productPath, s.getManifestResult)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range results {
+		productPath := results[i].granule.productPath()
+		checksums := make(map[string]FileChecksum)
+
+		for _, object := range objects[i] {
+			if checksum, ok := manifests[i].manifest[strings.TrimPrefix(object, productPath)]; ok {
+				checksums[path.Base(object)] = checksum
+			}
+		}
+
+		results[i].Checksums = checksums
+	}
+
+	return nil
+}
+
+// Acquisition of a tile, which may have been processed with several processing baselines
+type acquisition struct {
+	level       string
+	tile        string
+	sensingTime time.Time
+}
+
+// Get the acquisition of a granule
+// Product names of the old convention carry the start of their validity rather than the sensing time of the granule,
+// so the sensing time of the index is used, falling back to the product name for rows without one.
+func acquisitionOf(g Granule) acquisition {
+	sensingTime := g.SensingTime
+
+	if sensingTime.IsZero() {
+		sensingTime = g.Product.SensingTime
+	}
+
+	return acquisition{level: g.Name.Level, tile: g.Name.Tile, sensingTime: sensingTime}
+}
+
+// Keep only the granule of the newest processing baseline of every acquisition, keeping the order of the granules
+// Granules of the same baseline are told apart by the processing time in the product name.
+func latestBaselines(granules []Granule) []Granule {
+	latest := make(map[acquisition]int)
+
+	for i, g := range granules {
+		key := acquisitionOf(g)
+
+		if j, ok := latest[key]; !ok || newerProcessing(g, granules[j]) {
+			latest[key] = i
+		}
+	}
+
+	result := make([]Granule, 0, len(latest))
+
+	for i, g := range granules {
+		key := acquisitionOf(g)
+
+		if latest[key] == i {
+			result = append(result, g)
+		}
+	}
+
+	return result
+}
+
+// Check whether a granule was processed later than another granule of the same acquisition
+func newerProcessing(a, b Granule) bool {
+	// Baselines have the fixed format "NN.NN", so they compare as strings
+	if a.baseline() != b.baseline() {
+		return a.baseline() > b.baseline()
+	}
+
+	return a.Product.processingTime().After(b.Product.processingTime())
+}
+
+// Compute the cloud cover of the AOI for all results, dropping those above the maximum and sorting them when requested
+// Products of processing baseline 04.00 and later ship raster cloud masks only, which cannot be decoded here,
+// so the cloudy pixel percentage of the whole tile is used for results without a vector cloud mask.
+// Results whose cloud cover is still unknown never match a maximum and are sorted last.
+func (s *Server) filterClouds(results []GranuleImages, opts SearchOptions) ([]GranuleImages, error) {
+	masks, err := s.performGranules(resultGranules(results), Granule.qualityPath, s.getCloudResult)
+
+	if err != nil {
+		return nil, err
+	}
+
+	unmasked := make([]Granule, 0)
+
+	for i, res := range results {
+		if masks[i].mask == nil {
+			unmasked = append(unmasked, res.granule)
+		}
+	}
+
+	tiles, err := s.getTiles(unmasked)
+
+	if err != nil {
+		return nil, err
+	}
+
+	matching := results[:0]
+
+	for i, res := range results {
+		if masks[i].mask == nil {
+			tile := tiles[0]
+			tiles = tiles[1:]
+
+			if tile != nil {
+				percentage := tile.CloudyPixelPercentage
+				res.AOICloud = &percentage
+				res.AOICloudSource = "tile"
+			}
+		} else if fraction, ok := masks[i].mask.aoiFraction(opts.AOI, res.Bounds); ok {
+			percentage := 100 * fraction
+			res.AOICloud = &percentage
+			res.AOICloudSource = "mask"
+		}
+
+		if !math.IsNaN(opts.MaxAOICloud) && (res.AOICloud == nil || *res.AOICloud > opts.MaxAOICloud) {
+			continue
+		}
+
+		matching = append(matching, res)
+	}
+
+	if opts.Sort == "aoi_cloud" {
+		sort.SliceStable(matching, func(i, j int) bool {
+			a, b := matching[i].AOICloud, matching[j].AOICloud
+			return a != nil && (b == nil || *a < *b)
+		})
+	}
+
+	return matching, nil
+}
+
+// Compute the part of the AOI with valid data for all results, dropping those below the minimum when it is set
+// Results without a footprint have an unknown coverage, they never match a minimum.
+func (s *Server) filterCoverage(results []GranuleImages, opts SearchOptions) ([]GranuleImages, error) {
+	products, err := s.getProducts(resultGranules(results))
+
+	if err != nil {
+		return nil, err
+	}
+
+	matching := results[:0]
+
+	for i, res := range results {
+		fraction, ok := products[i].aoiCoverage(opts.AOI, res.Bounds)
+
+		if !ok {
+			if !opts.filtersCoverage() {
+				matching = append(matching, res)
+			}
+			continue
+		}
+
+		percentage := 100 * fraction
+		res.Coverage = &percentage
+
+		if opts.filtersCoverage() && percentage < opts.MinCoverage {
+			continue
+		}
+
+		matching = append(matching, res)
+	}
+
+	return matching, nil
+}
+
+// Get the AOI of a search for a single latitude/longitude coordinate
+func pointAOI(lat, lng float64) s2.CellUnion {
+	return s2.CellUnion{s2.CellIDFromLatLng(s2.LatLngFromDegrees(lat, lng))}
+}
+
+// Get the AOI of a search for bounds
+func boundsAOI(bounds []Bounds) s2.CellUnion {
+	coverings := make([]s2.CellUnion, 0, len(bounds))
+
+	for _, b := range bounds {
+		coverings = append(coverings, getCovering(b.rect(), AOICoveringOptions))
+	}
+
+	return s2.CellUnionFromUnion(coverings...)
+}
+
+// Get the granules of search results
+func resultGranules(results []GranuleImages) []Granule {
+	granules := make([]Granule, len(results))
+
+	for i, res := range results {
+		granules[i] = res.granule
+	}
+
+	return granules
+}
+
+// Set the pixel containing a latitude/longitude coordinate for all results with tile metadata
+func setPixels(results []GranuleImages, lat, lng float64) {
+	for i := range results {
+		if results[i].Tile == nil {
+			continue
+		}
+
+		if pixel, ok := results[i].Tile.pixel(lat, lng, PixelResolution); ok {
+			results[i].Pixel = &pixel
+		}
+	}
+}
+
+// Get the response of a search, a flat list of images unless metadata was requested
+func (opts SearchOptions) response(results []GranuleImages) interface{} {
+	if opts.Metadata {
+		return results
+	}
+
+	links := make([]string, 0)
+
+	for _, res := range results {
+		links = append(links, res.Images...)
+	}
+
+	return links
+}
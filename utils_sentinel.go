@@ -1,11 +1,9 @@
 package main
 
 import (
-	"fmt"
-	"math"
+	"log"
 	"path"
-	"strconv"
-	"strings"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/storage"
@@ -14,147 +12,151 @@ import (
 	"google.golang.org/api/iterator"
 )
 
-// ProjectID refers to Google Cloud project-id
-const ProjectID = "avon-178408"
+// Get number of images from all granules bounded by the cells of a covering
+// Only the newest processing baseline of every acquisition is counted, unless all baselines are requested.
+func (s *Server) getPolygonImages(cover s2.CellUnion, allBaselines bool) (int, error) {
+	// All cells of the covering are evaluated by a single query
+	granules, err := s.getGranules(coveringBounds(cover))
 
-//const ProjectID = "darb-178408"
-
-// Get number of images from all granules bounded by a polygon
-func getPolygonImages(degreePoints []Point) (int, error) {
-	points := make([]s2.Point, 0)
-
-	// Order matters here!
-	for i := len(degreePoints) - 1; i >= 0; i-- {
-		points = append(points, s2.PointFromLatLng(s2.LatLngFromDegrees(degreePoints[i].lat, degreePoints[i].lng)))
+	if err != nil {
+		return 0, err
 	}
 
-	l1 := s2.LoopFromPoints(points)
-	loops := []*s2.Loop{l1}
-	poly := s2.PolygonFromLoops(loops)
-
-	rc := &s2.RegionCoverer{MaxLevel: 30, MaxCells: 40}
-	cover := rc.Covering(poly)
-
-	var rect s2.Rect
+	if !allBaselines {
+		granules = latestBaselines(granules)
+	}
 
-	chAbort := make(chan error)
-	chGranules := make(chan []string)
+	count := len(granules) * 13
 
-	for i := 0; i < len(cover); i++ {
-		rect = s2.CellFromCellID(cover[i]).RectBound()
-		// Execute each request concurrently
-		go func(rect s2.Rect, chCount chan []string, chAbort chan error) {
-			ids, err := getGranuleIDs(strconv.FormatFloat(rect.RectBound().Lat.Lo*180.0/math.Pi, 'f', 6, 64),
-				strconv.FormatFloat(rect.RectBound().Lng.Lo*180.0/math.Pi, 'f', 6, 64),
-				strconv.FormatFloat(rect.RectBound().Lat.Hi*180.0/math.Pi, 'f', 6, 64),
-				strconv.FormatFloat(rect.RectBound().Lng.Hi*180.0/math.Pi, 'f', 6, 64))
+	return count, nil
+}
 
-			if err != nil {
-				chAbort <- err
-			} else {
-				chGranules <- ids
-			}
-		}(rect, chGranules, chAbort)
-	}
+// Granule is a single row of the Sentinel-2 index
+type Granule struct {
+	BaseURL   string
+	GranuleID string
+	// Bounds of the granule, West is greater than East for granules crossing the antimeridian
+	Bounds Bounds
+	// Sensing time of the index, zero when the index has none
+	SensingTime time.Time
+	// Names of the product and the granule, parsed from the base url and the granule id
+	Product ProductName
+	Name    GranuleName
+}
 
-	distinctIDs := make(map[string]bool)
+// Get the path of the product directory of the granule in the bucket, ending with a slash
+func (g Granule) productPath() string {
+	return tilePath(g.Name.Level, g.Name.Tile) + g.Product.String() + ".SAFE/"
+}
 
-	// Should get back exactly len(cover) messages
-	for i := 0; i < len(cover); i++ {
-		select {
-		case err := <-chAbort:
-			return 0, err
-		case ids := <-chGranules:
-			for _, id := range ids {
-				distinctIDs[id] = true
-			}
-		}
-	}
+// Get the path of the granule directory in the bucket, ending with a slash
+func (g Granule) path() string {
+	return g.productPath() + "GRANULE/" + g.Name.String() + "/"
+}
 
-	count := len(distinctIDs) * 13
+// Get the path of the directory holding the band images of the granule, ending with a slash
+func (g Granule) imagePath() string {
+	return g.path() + "IMG_DATA/"
+}
 
-	return count, nil
+// Get the path of the directory holding the quality indicators of the granule, ending with a slash
+func (g Granule) qualityPath() string {
+	return g.path() + "QI_DATA/"
 }
 
-// Get images in all granules intersecting specific geo bound
-// The "bound" can be specified as a single latitude/longitude coordinate or a tuple of latitude/longitude coordinates
-func getImageURLs(lat1 string, lng1 string, lat2 string, lng2 string) ([]string, error) {
+// Get the path of the product level metadata file of the granule
+func (g Granule) productMetadataPath() string {
+	return g.productPath() + g.Product.metadataName()
+}
 
-	var dbit *bigquery.RowIterator
-	var err error
+// Get the path of the granule level metadata file of the granule
+func (g Granule) tileMetadataPath() string {
+	return g.path() + g.Name.metadataName()
+}
 
-	if lat2 == "" && lng2 == "" {
-		dbit, err = getBaseURLs(lng1, lat1)
-	} else {
-		dbit, err = getBaseURLs2(lng1, lat1, lng2, lat2)
+// Get the processing baseline of the granule
+// Products of the old naming convention carry the baseline in the names of their granules.
+func (g Granule) baseline() string {
+	if g.Product.Baseline != "" {
+		return g.Product.Baseline
 	}
 
-	if err != nil {
-		return nil, err
-	}
+	return g.Name.Baseline
+}
 
-	links := make([]string, 0)
+// Get the images of every granule, listing each granule once using the worker pool
+// The media links of the images are returned along with their object names.
+func (s *Server) listGranuleImages(granules []Granule) ([][]string, [][]string, error) {
 
-	// This seems ridiculous but the bigquery.RowIterator does not seem to have a field with the number of rows
-	rowCount := 0
+	results, err := s.performGranules(granules, Granule.imagePath, s.getImages)
 
-	chReq := make(chan GranuleRequest)
-	chResp := make(chan GranuleResult, 100)
-	chAbort := make(chan error)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// This can deadlick if the second argument is not big enough since the worker buffer gets filled-up,
-	// however nobody is taking out the responses
-	balancer := NewBalancer(100, 50)
+	images := make([][]string, len(results))
+	objects := make([][]string, len(results))
 
-	// Start-up the load balancer
-	go balancer.Balance(chReq, chAbort)
+	for i, res := range results {
+		images[i] = res.urls
+		objects[i] = res.objects
+	}
 
-	for {
-		var row []bigquery.Value
+	return images, objects, nil
+}
 
-		err := dbit.Next(&row)
+// Perform a request for the path of every granule using the worker pool, keeping the order of the granules
+func (s *Server) performGranules(granules []Granule, path func(Granule) string, fn func(string) GranuleResult) ([]GranuleResult, error) {
 
-		if err == iterator.Done {
-			break
-		}
+	// Responses are buffered for all granules, so the workers never block on sending them
+	chResp := make(chan GranuleResult, len(granules))
 
-		rowCount++
+	// Stops sending requests when a response fails
+	quit := make(chan struct{})
+	defer close(quit)
 
-		if err != nil {
-			return nil, err
+	go func() {
+		for i, g := range granules {
+			select {
+			case s.balancer.work <- GranuleRequest{ch: chResp, url: path(g), index: i, fn: fn}:
+			case <-quit:
+				return
+			case <-s.balancer.quit:
+				return
+			}
 		}
+	}()
 
-		url := strings.TrimPrefix(row[0].(string), "gs://gcp-public-data-sentinel-2/") + "/GRANULE/" + row[1].(string) + "/IMG_DATA/"
-
-		chReq <- GranuleRequest{ch: chResp, url: url, fn: getImages}
-	}
+	results := make([]GranuleResult, len(granules))
 
 	// Wait for responses from all go-routines
-	for i := 0; i < rowCount; i++ {
+	for i := 0; i < len(granules); i++ {
 		select {
 		case resp := <-chResp:
 			if resp.err != nil {
-				chAbort <- resp.err
 				return nil, resp.err
 			}
-			links = append(links, resp.urls...)
+			results[resp.index] = resp
+		case <-s.balancer.quit:
+			return nil, ErrBalancerStopped
 		}
 	}
 
-	return links, nil
+	return results, nil
 }
 
 // Get images for a specific path from Google Cloud Storage
-func getImages(_path string) GranuleResult {
+func (s *Server) getImages(_path string) GranuleResult {
 
 	res := GranuleResult{}
 
 	ctx := context.Background()
 	urls := make([]string, 0)
+	objects := make([]string, 0)
 
 	query := &storage.Query{Prefix: _path}
 
-	it := StorageBucket.Objects(ctx, query)
+	it := s.bucket.Objects(ctx, query)
 
 	for {
 		objAttrs, err := it.Next()
@@ -171,127 +173,74 @@ func getImages(_path string) GranuleResult {
 		// Get only .jp2 files
 		if path.Ext(objAttrs.Name) == ".jp2" {
 			urls = append(urls, objAttrs.MediaLink)
+			objects = append(objects, objAttrs.Name)
 		}
 	}
 
 	res.urls = urls
+	res.objects = objects
 	return res
 }
 
-// Get urls for all granules intersecting single (latitude, longitude) coordinate
-func getBaseURLs(lng string, lat string) (*bigquery.RowIterator, error) {
-	ctx := context.Background()
-
-	client, err := bigquery.NewClient(ctx, ProjectID)
-
-	if err != nil {
-		return nil, err
+// Get all granules intersecting any of the bounds using a single query
+func (s *Server) getGranules(bounds []Bounds) ([]Granule, error) {
+	if len(bounds) == 0 {
+		return []Granule{}, nil
 	}
 
-	sql := fmt.Sprintf(`SELECT base_url, granule_id 
-		FROM`+" `bigquery-public-data.cloud_storage_geo_index.sentinel_2_index` "+
-		`WHERE south_lat <= %s AND north_lat >= %s AND west_lon <= %s AND east_lon >= %s;`,
-		lat, lat, lng, lng)
-
-	query := client.Query(sql)
-	query.QueryConfig.UseStandardSQL = true
-
-	return query.Read(ctx)
-}
-
-// Get urls for all granules intersecting two (latitude, longitude) coordinates
-func getBaseURLs2(lng1 string, lat1 string, lng2 string, lat2 string) (*bigquery.RowIterator, error) {
-
-	var err error
-
 	ctx := context.Background()
 
-	client, err := bigquery.NewClient(ctx, ProjectID)
+	// The bounds are passed as a single array parameter, keeping the query short for large coverings
+	// The bounds of a box crossing the antimeridian may both match the same granule
+	sql := `SELECT DISTINCT base_url, granule_id, south_lat, west_lon, north_lat, east_lon, sensing_time 
+		FROM` + " `bigquery-public-data.cloud_storage_geo_index.sentinel_2_index`, UNNEST(@bounds) AS b " +
+		`WHERE ` + BoundsPredicate
 
-	if err != nil {
-		return nil, err
-	}
-
-	// The granule intersects any of the (top, left), (bottom, left), (top, right), (bottom, right) corners of the input-bounds
-	// The input-bounds is fully within the granule
-	// The granule bounds is fully contained in input-bounds
-	sql := fmt.Sprintf(`SELECT base_url, granule_id 
-		FROM`+" `bigquery-public-data.cloud_storage_geo_index.sentinel_2_index` "+
-		`WHERE 
-			(((south_lat BETWEEN %s AND %s) OR (north_lat BETWEEN %s AND %s)) AND ((west_lon BETWEEN %s AND %s) OR (east_lon BETWEEN %s AND %s))) OR
-			((%s BETWEEN south_lat AND north_lat AND %s BETWEEN south_lat AND north_lat) AND (%s BETWEEN west_lon AND east_lon AND %s BETWEEN west_lon AND east_lon)) OR
-			((south_lat BETWEEN %s AND %s AND north_lat BETWEEN %s AND %s) AND (west_lon BETWEEN %s AND %s AND east_lon BETWEEN %s AND %s))
-		`, lat1, lat2, lat1, lat2, lng1, lng2, lng1, lng2, lat1, lat2, lng1, lng2, lat1, lat2, lat1, lat2, lng1, lng2, lng1, lng2)
-
-	query := client.Query(sql)
+	query := s.bigquery.Query(sql)
 	query.QueryConfig.UseStandardSQL = true
+	query.QueryConfig.Parameters = []bigquery.QueryParameter{{Name: "bounds", Value: bounds}}
 
-	return query.Read(ctx)
-}
-
-// Get a handle to a bucket in Google Cloud Storage specified by a bucket-id
-func getBucketHandle(bucketID string) (*storage.BucketHandle, error) {
-
-	ctx := context.Background()
-
-	client, err := storage.NewClient(ctx)
+	dbit, err := query.Read(ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return client.Bucket(bucketID), nil
-}
-
-// Get all granule ids intersecting two (latitude, longitude) coordinates
-func getGranuleIDs(lat1 string, lng1 string, lat2 string, lng2 string) ([]string, error) {
-	var err error
-	var dbit *bigquery.RowIterator
+	granules := make([]Granule, 0)
 
-	ctx := context.Background()
-
-	client, err := bigquery.NewClient(ctx, ProjectID)
-
-	if err != nil {
-		return nil, err
-	}
+	// The schema is only known once the first row has been fetched
+	validated := false
 
-	// The granule intersects any of the (top, left), (bottom, left), (top, right), (bottom, right) corners of the input-bounds
-	// The input-bounds is fully within the granule
-	// The granule bounds is fully contained in input-bounds
-	sql := fmt.Sprintf(`SELECT granule_id 
-		FROM`+" `bigquery-public-data.cloud_storage_geo_index.sentinel_2_index` "+
-		`WHERE 
-			(((south_lat BETWEEN %s AND %s) OR (north_lat BETWEEN %s AND %s)) AND ((west_lon BETWEEN %s AND %s) OR (east_lon BETWEEN %s AND %s))) OR
-			((%s BETWEEN south_lat AND north_lat AND %s BETWEEN south_lat AND north_lat) AND (%s BETWEEN west_lon AND east_lon AND %s BETWEEN west_lon AND east_lon)) OR
-			((south_lat BETWEEN %s AND %s AND north_lat BETWEEN %s AND %s) AND (west_lon BETWEEN %s AND %s AND east_lon BETWEEN %s AND %s))
-		`, lat1, lat2, lat1, lat2, lng1, lng2, lng1, lng2, lat1, lat2, lng1, lng2, lat1, lat2, lat1, lat2, lng1, lng2, lng1, lng2)
-
-	query := client.Query(sql)
-	query.QueryConfig.UseStandardSQL = true
+	for {
+		var row granuleRow
 
-	dbit, err = query.Read(ctx)
+		err := dbit.Next(&row)
 
-	if err != nil {
-		return nil, err
-	}
+		if err == iterator.Done {
+			break
+		}
 
-	var row []bigquery.Value
+		if !validated && dbit.Schema != nil {
+			if err := validateSchema(dbit.Schema, granuleColumns); err != nil {
+				return nil, err
+			}
+			validated = true
+		}
 
-	granuleIDs := make([]string, 0)
+		if err != nil {
+			return nil, err
+		}
 
-	for {
-		err := dbit.Next(&row)
+		granule, err := row.granule()
 
-		if err == iterator.Done {
-			break
+		// A single bad row is skipped rather than failing the whole search
+		if err != nil {
+			log.Printf("Skipping %s", err.Error())
+			continue
 		}
-		granuleIDs = append(granuleIDs, row[0].(string))
-	}
 
-	if err != nil {
-		return nil, err
+		granules = append(granules, granule)
 	}
 
-	return granuleIDs, nil
+	return granules, nil
 }
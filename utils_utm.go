@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Parameters of the WGS84 ellipsoid and the UTM projection
+const (
+	wgs84A          = 6378137.0
+	wgs84F          = 1 / 298.257223563
+	utmScale        = 0.9996
+	utmFalseEast    = 500000.0
+	utmFalseNorth   = 10000000.0
+	epsgUTMNorth    = 32600
+	epsgUTMSouth    = 32700
+	utmZoneCount    = 60
+	utmZoneWidthDeg = 6.0
+)
+
+// UTMZone is a zone of the WGS84 / UTM projection
+type UTMZone struct {
+	Zone  int
+	North bool
+}
+
+// Get the UTM zone of an EPSG code such as "EPSG:32633"
+func parseUTMZone(code string) (UTMZone, error) {
+	epsg, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(code)), "EPSG:"))
+
+	if err != nil {
+		return UTMZone{}, fmt.Errorf("Invalid EPSG code %q", code)
+	}
+
+	switch {
+	case epsg > epsgUTMNorth && epsg <= epsgUTMNorth+utmZoneCount:
+		return UTMZone{Zone: epsg - epsgUTMNorth, North: true}, nil
+	case epsg > epsgUTMSouth && epsg <= epsgUTMSouth+utmZoneCount:
+		return UTMZone{Zone: epsg - epsgUTMSouth, North: false}, nil
+	}
+
+	return UTMZone{}, fmt.Errorf("EPSG code %q is not a WGS84 / UTM zone", code)
+}
+
+// Get the central meridian of the zone in radians
+func (z UTMZone) centralMeridian() float64 {
+	return (float64(z.Zone-1)*utmZoneWidthDeg - 180 + utmZoneWidthDeg/2) * math.Pi / 180
+}
+
+// Project a latitude/longitude coordinate in degrees to easting and northing in meters
+// The series expansion is accurate to well below a pixel within a few degrees of the central meridian,
+// which covers the tiles of a zone including their overlap with the neighbouring zones.
+func (z UTMZone) forward(lat, lng float64) (float64, float64) {
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+
+	phi := lat * math.Pi / 180
+	lambda := lng*math.Pi/180 - z.centralMeridian()
+
+	// Keep the longitude difference within (-pi, pi] for coordinates near the antimeridian
+	lambda = math.Remainder(lambda, 2*math.Pi)
+
+	sinPhi, cosPhi := math.Sincos(phi)
+	tanPhi := math.Tan(phi)
+
+	n := wgs84A / math.Sqrt(1-e2*sinPhi*sinPhi)
+	t := tanPhi * tanPhi
+	c := ep2 * cosPhi * cosPhi
+	a := lambda * cosPhi
+
+	m := meridianArc(phi)
+
+	x := utmScale * n * (a + (1-t+c)*math.Pow(a, 3)/6 +
+		(5-18*t+t*t+72*c-58*ep2)*math.Pow(a, 5)/120)
+
+	y := utmScale * (m + n*tanPhi*(a*a/2+(5-t+9*c+4*c*c)*math.Pow(a, 4)/24+
+		(61-58*t+t*t+600*c-330*ep2)*math.Pow(a, 6)/720))
+
+	if !z.North {
+		y += utmFalseNorth
+	}
+
+	return x + utmFalseEast, y
+}
+
+// Get the distance along the meridian from the equator to a latitude in radians
+func meridianArc(phi float64) float64 {
+	e2 := wgs84F * (2 - wgs84F)
+	e4 := e2 * e2
+	e6 := e4 * e2
+
+	return wgs84A * ((1-e2/4-3*e4/64-5*e6/256)*phi -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*phi) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*phi) -
+		(35*e6/3072)*math.Sin(6*phi))
+}
+
+// Project easting and northing in meters back to a latitude/longitude coordinate in degrees
+func (z UTMZone) inverse(x, y float64) (float64, float64) {
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+	e4 := e2 * e2
+	e6 := e4 * e2
+
+	x -= utmFalseEast
+
+	if !z.North {
+		y -= utmFalseNorth
+	}
+
+	// Footpoint latitude of the meridian arc
+	mu := y / utmScale / (wgs84A * (1 - e2/4 - 3*e4/64 - 5*e6/256))
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+
+	phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sinPhi1, cosPhi1 := math.Sincos(phi1)
+	tanPhi1 := math.Tan(phi1)
+
+	c1 := ep2 * cosPhi1 * cosPhi1
+	t1 := tanPhi1 * tanPhi1
+	n1 := wgs84A / math.Sqrt(1-e2*sinPhi1*sinPhi1)
+	r1 := wgs84A * (1 - e2) / math.Pow(1-e2*sinPhi1*sinPhi1, 1.5)
+	d := x / (n1 * utmScale)
+
+	phi := phi1 - (n1*tanPhi1/r1)*(d*d/2-
+		(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+
+	lambda := (d - (1+2*t1+c1)*math.Pow(d, 3)/6 +
+		(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / cosPhi1
+
+	lng := (z.centralMeridian() + lambda) * 180 / math.Pi
+
+	// Wrap longitudes of zones next to the antimeridian back into [-180, 180]
+	if lng > 180 {
+		lng -= 360
+	} else if lng < -180 {
+		lng += 360
+	}
+
+	return phi * 180 / math.Pi, lng
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseUTMZone(t *testing.T) {
+	tests := []struct {
+		code string
+		zone UTMZone
+		ok   bool
+	}{
+		{code: "EPSG:32633", zone: UTMZone{Zone: 33, North: true}, ok: true},
+		{code: "epsg:32601", zone: UTMZone{Zone: 1, North: true}, ok: true},
+		{code: " 32660 ", zone: UTMZone{Zone: 60, North: true}, ok: true},
+		{code: "EPSG:32701", zone: UTMZone{Zone: 1, North: false}, ok: true},
+		{code: "EPSG:32760", zone: UTMZone{Zone: 60, North: false}, ok: true},
+		{code: "EPSG:32600"},
+		{code: "EPSG:32661"},
+		{code: "EPSG:32700"},
+		{code: "EPSG:4326"},
+		{code: "EPSG:"},
+		{code: "UTM33N"},
+	}
+
+	for _, test := range tests {
+		zone, err := parseUTMZone(test.code)
+
+		if (err == nil) != test.ok || zone != test.zone {
+			t.Errorf("%q: zone %+v, error %v", test.code, zone, err)
+		}
+	}
+}
+
+func TestUTMForward(t *testing.T) {
+	tests := []struct {
+		name     string
+		zone     UTMZone
+		lat, lng float64
+		x, y     float64
+	}{
+		{name: "equator on the central meridian", zone: UTMZone{Zone: 31, North: true}, lat: 0, lng: 3, x: 500000, y: 0},
+		{name: "equator in the south", zone: UTMZone{Zone: 31, North: false}, lat: 0, lng: 3, x: 500000, y: 10000000},
+		// The meridian arc from the equator to 45° is 4984944.378 m on WGS84
+		{name: "45° north on the central meridian", zone: UTMZone{Zone: 33, North: true}, lat: 45, lng: 15, x: 500000, y: 0.9996 * 4984944.378},
+		{name: "45° south on the central meridian", zone: UTMZone{Zone: 33, North: false}, lat: -45, lng: 15, x: 500000, y: 10000000 - 0.9996*4984944.378},
+		{name: "zone 1 on the central meridian", zone: UTMZone{Zone: 1, North: true}, lat: 0, lng: -177, x: 500000, y: 0},
+		{name: "zone 60 on the central meridian", zone: UTMZone{Zone: 60, North: true}, lat: 0, lng: 177, x: 500000, y: 0},
+	}
+
+	for _, test := range tests {
+		x, y := test.zone.forward(test.lat, test.lng)
+
+		if math.Abs(x-test.x) > 0.01 || math.Abs(y-test.y) > 0.01 {
+			t.Errorf("%s: projected to (%.3f, %.3f), expected (%.3f, %.3f)", test.name, x, y, test.x, test.y)
+		}
+	}
+}
+
+func TestUTMForwardSymmetry(t *testing.T) {
+	zone := UTMZone{Zone: 33, North: true}
+
+	// Points mirrored at the central meridian have mirrored eastings
+	for _, lat := range []float64{0, 30, 60, 80} {
+		xWest, yWest := zone.forward(lat, 12)
+		xEast, yEast := zone.forward(lat, 18)
+
+		if math.Abs((xWest-utmFalseEast)+(xEast-utmFalseEast)) > 1e-6 || math.Abs(yWest-yEast) > 1e-6 {
+			t.Errorf("latitude %g: (%.3f, %.3f) and (%.3f, %.3f) are not symmetric", lat, xWest, yWest, xEast, yEast)
+		}
+	}
+}
+
+func TestUTMRoundTrip(t *testing.T) {
+	zones := []UTMZone{
+		{Zone: 1, North: true},
+		{Zone: 1, North: false},
+		{Zone: 33, North: true},
+		{Zone: 33, North: false},
+		{Zone: 60, North: true},
+		{Zone: 60, North: false},
+	}
+
+	// Offsets from the central meridian in degrees: on it, at the zone edges and within the overlap of the tiles
+	offsets := []float64{0, -1.5, 1.5, -3, 3, -3.5, 3.5}
+
+	for _, zone := range zones {
+		lats := []float64{0.5, 15, 45, 70, 84}
+
+		if !zone.North {
+			lats = []float64{-0.5, -15, -45, -70, -80}
+		}
+
+		for _, lat := range lats {
+			for _, offset := range offsets {
+				lng := zone.centralMeridian()*180/math.Pi + offset
+
+				if lng > 180 {
+					lng -= 360
+				} else if lng < -180 {
+					lng += 360
+				}
+
+				x, y := zone.forward(lat, lng)
+				lat2, lng2 := zone.inverse(x, y)
+
+				// About a centimeter, longitudes of 180 and -180 are the same
+				if math.Abs(lat2-lat) > 1e-7 || math.Abs(math.Remainder(lng2-lng, 360)) > 1e-7 {
+					t.Errorf("zone %+v: (%g, %g) returned as (%.9f, %.9f)", zone, lat, lng, lat2, lng2)
+				}
+			}
+		}
+	}
+}
@@ -4,62 +4,617 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"log"
+	"math"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/golang/geo/s2"
 	"golang.org/x/net/context"
 	"google.golang.org/api/iterator"
 )
 
-type InnerResult struct {
-	Value string `xml:",innerxml"`
+// ProductMetadata holds the product level metadata of a Sentinel-2 product (MTD_MSIL1C.xml or MTD_MSIL2A.xml)
+type ProductMetadata struct {
+	ProductURI         string    `json:"product_uri"`
+	ProductType        string    `json:"product_type"`
+	ProcessingLevel    string    `json:"processing_level"`
+	ProcessingBaseline string    `json:"processing_baseline"`
+	SensingStart       time.Time `json:"sensing_start"`
+	SensingStop        time.Time `json:"sensing_stop"`
+	GenerationTime     time.Time `json:"generation_time"`
+	Spacecraft         string    `json:"spacecraft"`
+	RelativeOrbit      int       `json:"relative_orbit"`
+	OrbitDirection     string    `json:"orbit_direction"`
+	// Digital numbers are converted to reflectance by (DN + offset) / QuantificationValue
+	QuantificationValue float64 `json:"quantification_value"`
+	// Earth-Sun distance correction factor
+	ReflectanceConversion float64        `json:"reflectance_conversion"`
+	Bands                 []BandMetadata `json:"bands"`
+	// Rings of the footprint with valid data as [longitude, latitude] pairs
+	Footprint [][][2]float64 `json:"footprint"`
 }
 
-type SpectralInformation struct {
-	bands []string `xml:"Product_Image_Characteristics>Spectral_Information_List>Spectral_Information"`
+// BandMetadata holds the spectral characteristics of a single band
+type BandMetadata struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Wavelengths are given in nm
+	CentralWavelength float64 `json:"central_wavelength"`
+	Bandwidth         float64 `json:"bandwidth"`
+	// Resolution is given in m
+	Resolution        int     `json:"resolution"`
+	SolarIrradiance   float64 `json:"solar_irradiance"`
+	ReflectanceOffset float64 `json:"reflectance_offset"`
 }
 
-func getMetadata(path string) (int, int, int, error) {
-	pathMetadata, err := getMetadataPath(path)
+// Value of a band, identified by the attribute band_id or bandId
+type bandValue struct {
+	BandID  string  `xml:"band_id,attr"`
+	BandID2 string  `xml:"bandId,attr"`
+	Value   float64 `xml:",chardata"`
+}
+
+// Get the id of the band a value belongs to
+func (v bandValue) band() string {
+	if v.BandID != "" {
+		return v.BandID
+	}
+	return v.BandID2
+}
+
+// Layout of MTD_MSIL1C.xml and MTD_MSIL2A.xml, elements are matched regardless of their namespace
+type productMetadataXML struct {
+	ProductInfo struct {
+		ProductURI         string    `xml:"PRODUCT_URI"`
+		ProductType        string    `xml:"PRODUCT_TYPE"`
+		ProcessingLevel    string    `xml:"PROCESSING_LEVEL"`
+		ProcessingBaseline string    `xml:"PROCESSING_BASELINE"`
+		StartTime          time.Time `xml:"PRODUCT_START_TIME"`
+		StopTime           time.Time `xml:"PRODUCT_STOP_TIME"`
+		GenerationTime     time.Time `xml:"GENERATION_TIME"`
+		Datatake           struct {
+			Spacecraft     string `xml:"SPACECRAFT_NAME"`
+			Orbit          int    `xml:"SENSING_ORBIT_NUMBER"`
+			OrbitDirection string `xml:"SENSING_ORBIT_DIRECTION"`
+		} `xml:"Datatake"`
+	} `xml:"General_Info>Product_Info"`
+	ImageCharacteristics struct {
+		// Level-1C
+		QuantificationValue float64     `xml:"QUANTIFICATION_VALUE"`
+		RadiometricOffsets  []bandValue `xml:"Radiometric_Offset_List>RADIO_ADD_OFFSET"`
+		// Level-2A, the list was renamed with processing baseline 02.07
+		BOAQuantificationValue    float64     `xml:"QUANTIFICATION_VALUES_LIST>BOA_QUANTIFICATION_VALUE"`
+		BOAQuantificationValueOld float64     `xml:"L1C_L2A_Quantification_Values_List>L2A_BOA_QUANTIFICATION_VALUE"`
+		BOAOffsets                []bandValue `xml:"BOA_ADD_OFFSET_VALUES_LIST>BOA_ADD_OFFSET"`
+		ReflectanceConversion     struct {
+			U               float64     `xml:"U"`
+			SolarIrradiance []bandValue `xml:"Solar_Irradiance_List>SOLAR_IRRADIANCE"`
+		} `xml:"Reflectance_Conversion"`
+		SpectralInformation []struct {
+			BandID       int     `xml:"bandId,attr"`
+			PhysicalBand string  `xml:"physicalBand,attr"`
+			Resolution   int     `xml:"RESOLUTION"`
+			Min          float64 `xml:"Wavelength>MIN"`
+			Max          float64 `xml:"Wavelength>MAX"`
+			Central      float64 `xml:"Wavelength>CENTRAL"`
+		} `xml:"Spectral_Information_List>Spectral_Information"`
+	} `xml:"General_Info>Product_Image_Characteristics"`
+	// Positions are given as "lat lng lat lng ..."
+	Footprints []string `xml:"Geometric_Info>Product_Footprint>Product_Footprint>Global_Footprint>EXT_POS_LIST"`
+}
+
+// Parse the product level metadata of a Level-1C or Level-2A product
+func parseProductMetadata(data []byte) (*ProductMetadata, error) {
+	var doc productMetadataXML
+
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	info, chars := doc.ProductInfo, doc.ImageCharacteristics
+
+	if len(chars.SpectralInformation) == 0 {
+		return nil, errors.New("Product metadata does not contain any spectral information")
+	}
+
+	m := &ProductMetadata{
+		ProductURI:            info.ProductURI,
+		ProductType:           info.ProductType,
+		ProcessingLevel:       info.ProcessingLevel,
+		ProcessingBaseline:    info.ProcessingBaseline,
+		SensingStart:          info.StartTime,
+		SensingStop:           info.StopTime,
+		GenerationTime:        info.GenerationTime,
+		Spacecraft:            info.Datatake.Spacecraft,
+		RelativeOrbit:         info.Datatake.Orbit,
+		OrbitDirection:        info.Datatake.OrbitDirection,
+		QuantificationValue:   chars.QuantificationValue,
+		ReflectanceConversion: chars.ReflectanceConversion.U,
+	}
+
+	offsets := chars.RadiometricOffsets
+
+	if m.QuantificationValue == 0 {
+		m.QuantificationValue = chars.BOAQuantificationValue
+		offsets = chars.BOAOffsets
+	}
+
+	if m.QuantificationValue == 0 {
+		m.QuantificationValue = chars.BOAQuantificationValueOld
+	}
+
+	if m.QuantificationValue == 0 {
+		return nil, errors.New("Product metadata does not contain a quantification value")
+	}
+
+	offsetByBand := make(map[string]float64)
+	for _, v := range offsets {
+		offsetByBand[v.band()] = v.Value
+	}
+
+	irradianceByBand := make(map[string]float64)
+	for _, v := range chars.ReflectanceConversion.SolarIrradiance {
+		irradianceByBand[v.band()] = v.Value
+	}
+
+	for _, info := range chars.SpectralInformation {
+		id := strconv.Itoa(info.BandID)
+
+		m.Bands = append(m.Bands, BandMetadata{
+			ID:                info.BandID,
+			Name:              info.PhysicalBand,
+			CentralWavelength: info.Central,
+			Bandwidth:         info.Max - info.Min,
+			Resolution:        info.Resolution,
+			SolarIrradiance:   irradianceByBand[id],
+			ReflectanceOffset: offsetByBand[id],
+		})
+	}
+
+	for _, footprint := range doc.Footprints {
+		ring, err := parsePosList(footprint)
+
+		if err != nil {
+			return nil, err
+		}
+
+		m.Footprint = append(m.Footprint, ring)
+	}
+
+	return m, nil
+}
+
+// Parse a list of "lat lng" positions to [longitude, latitude] pairs
+func parsePosList(list string) ([][2]float64, error) {
+	values := strings.Fields(list)
+
+	if len(values)%2 != 0 {
+		return nil, errors.New("Position list must have an even number of values")
+	}
+
+	ring := make([][2]float64, 0, len(values)/2)
+
+	for i := 0; i < len(values); i += 2 {
+		lat, lng, err := parseLatLng(values[i], values[i+1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		ring = append(ring, [2]float64{lng, lat})
+	}
+
+	return ring, nil
+}
 
-	fmt.Println(pathMetadata)
+// Get the polygons of the footprint, rings which are not valid are skipped
+func (m *ProductMetadata) footprintPolygons() []*s2.Polygon {
+	polygons := make([]*s2.Polygon, 0, len(m.Footprint))
+
+	for _, footprint := range m.Footprint {
+		points := make([]Point, 0, len(footprint))
+
+		for _, p := range footprint {
+			points = append(points, Point{lat: p[1], lng: p[0]})
+		}
+
+		ring, err := normalizeRing(points)
+
+		if err != nil {
+			log.Printf("Skipping footprint of %s: %s", m.ProductURI, err.Error())
+			continue
+		}
+
+		polygons = append(polygons, polygonFromPoints(ring))
+	}
+
+	return polygons
+}
+
+// Get the fraction of an AOI within the bounds of a granule which has valid data
+// The second return value is false when the metadata has no valid footprint or the AOI does not overlap the granule.
+func (m *ProductMetadata) aoiCoverage(aoi s2.CellUnion, bounds Bounds) (float64, bool) {
+	if m == nil {
+		return 0, false
+	}
+
+	polygons := m.footprintPolygons()
+
+	if len(polygons) == 0 {
+		return 0, false
+	}
+
+	return aoiFraction(aoi, bounds, polygons)
+}
+
+// TileMetadata holds the granule level metadata of a Sentinel-2 tile (MTD_TL.xml)
+type TileMetadata struct {
+	TileID      string    `json:"tile_id"`
+	SensingTime time.Time `json:"sensing_time"`
+	// Coordinate reference system of the tile, such as "EPSG:32633"
+	EPSG         string            `json:"epsg"`
+	Geopositions []TileGeoposition `json:"geopositions"`
+	// Angles are given in degrees
+	SunZenith             float64        `json:"sun_zenith"`
+	SunAzimuth            float64        `json:"sun_azimuth"`
+	ViewingAngles         []ViewingAngle `json:"viewing_angles"`
+	CloudyPixelPercentage float64        `json:"cloudy_pixel_percentage"`
+}
+
+// TileGeoposition holds the raster geometry of a tile at a single resolution
+type TileGeoposition struct {
+	Resolution int `json:"resolution"`
+	// Coordinates of the upper left corner in the CRS of the tile
+	ULX  float64 `json:"ulx"`
+	ULY  float64 `json:"uly"`
+	XDim float64 `json:"xdim"`
+	YDim float64 `json:"ydim"`
+	Rows int     `json:"rows"`
+	Cols int     `json:"cols"`
+}
+
+// ViewingAngle holds the mean viewing incidence angles of a single band
+type ViewingAngle struct {
+	BandID  int     `json:"band_id"`
+	Zenith  float64 `json:"zenith"`
+	Azimuth float64 `json:"azimuth"`
+}
+
+// Layout of MTD_TL.xml, elements are matched regardless of their namespace
+type tileMetadataXML struct {
+	TileID      string    `xml:"General_Info>TILE_ID"`
+	SensingTime time.Time `xml:"General_Info>SENSING_TIME"`
+	Geocoding   struct {
+		EPSG  string `xml:"HORIZONTAL_CS_CODE"`
+		Sizes []struct {
+			Resolution int `xml:"resolution,attr"`
+			Rows       int `xml:"NROWS"`
+			Cols       int `xml:"NCOLS"`
+		} `xml:"Size"`
+		Geopositions []struct {
+			Resolution int     `xml:"resolution,attr"`
+			ULX        float64 `xml:"ULX"`
+			ULY        float64 `xml:"ULY"`
+			XDim       float64 `xml:"XDIM"`
+			YDim       float64 `xml:"YDIM"`
+		} `xml:"Geoposition"`
+	} `xml:"Geometric_Info>Tile_Geocoding"`
+	Angles struct {
+		SunZenith     float64 `xml:"Mean_Sun_Angle>ZENITH_ANGLE"`
+		SunAzimuth    float64 `xml:"Mean_Sun_Angle>AZIMUTH_ANGLE"`
+		ViewingAngles []struct {
+			BandID  int     `xml:"bandId,attr"`
+			Zenith  float64 `xml:"ZENITH_ANGLE"`
+			Azimuth float64 `xml:"AZIMUTH_ANGLE"`
+		} `xml:"Mean_Viewing_Incidence_Angle_List>Mean_Viewing_Incidence_Angle"`
+	} `xml:"Geometric_Info>Tile_Angles"`
+	CloudyPixelPercentage float64 `xml:"Quality_Indicators_Info>Image_Content_QI>CLOUDY_PIXEL_PERCENTAGE"`
+}
+
+// Parse the granule level metadata of a Level-1C or Level-2A tile
+func parseTileMetadata(data []byte) (*TileMetadata, error) {
+	var doc tileMetadataXML
+
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Geocoding.Geopositions) == 0 {
+		return nil, errors.New("Tile metadata does not contain a geoposition")
+	}
+
+	if _, err := parseUTMZone(doc.Geocoding.EPSG); err != nil {
+		return nil, err
+	}
+
+	m := &TileMetadata{
+		TileID:                doc.TileID,
+		SensingTime:           doc.SensingTime,
+		EPSG:                  doc.Geocoding.EPSG,
+		SunZenith:             doc.Angles.SunZenith,
+		SunAzimuth:            doc.Angles.SunAzimuth,
+		CloudyPixelPercentage: doc.CloudyPixelPercentage,
+	}
+
+	sizes := make(map[int][2]int)
+	for _, size := range doc.Geocoding.Sizes {
+		sizes[size.Resolution] = [2]int{size.Rows, size.Cols}
+	}
+
+	for _, pos := range doc.Geocoding.Geopositions {
+		size, ok := sizes[pos.Resolution]
+
+		if !ok {
+			return nil, fmt.Errorf("Tile metadata does not contain the size at resolution %d", pos.Resolution)
+		}
+
+		m.Geopositions = append(m.Geopositions, TileGeoposition{
+			Resolution: pos.Resolution,
+			ULX:        pos.ULX,
+			ULY:        pos.ULY,
+			XDim:       pos.XDim,
+			YDim:       pos.YDim,
+			Rows:       size[0],
+			Cols:       size[1],
+		})
+	}
+
+	for _, angle := range doc.Angles.ViewingAngles {
+		m.ViewingAngles = append(m.ViewingAngles, ViewingAngle{BandID: angle.BandID, Zenith: angle.Zenith, Azimuth: angle.Azimuth})
+	}
+
+	return m, nil
+}
+
+// Get the largest mean viewing zenith angle of all bands
+func (m *TileMetadata) maxViewZenith() float64 {
+	zenith := 0.0
+
+	for _, angle := range m.ViewingAngles {
+		zenith = math.Max(zenith, angle.Zenith)
+	}
+
+	return zenith
+}
+
+// PixelPosition is the position of a pixel in the raster of a tile
+type PixelPosition struct {
+	Resolution int `json:"resolution"`
+	Row        int `json:"row"`
+	Col        int `json:"col"`
+}
+
+// Get the pixel of the tile raster at a resolution containing a latitude/longitude coordinate
+// The second return value is false when the coordinate lies outside of the tile.
+func (m *TileMetadata) pixel(lat, lng float64, resolution int) (PixelPosition, bool) {
+	zone, err := parseUTMZone(m.EPSG)
+
+	if err != nil {
+		return PixelPosition{}, false
+	}
+
+	for _, pos := range m.Geopositions {
+		if pos.Resolution != resolution || pos.XDim == 0 || pos.YDim == 0 {
+			continue
+		}
+
+		x, y := zone.forward(lat, lng)
+
+		// YDIM is negative, rows are counted downwards from the upper left corner
+		col := int(math.Floor((x - pos.ULX) / pos.XDim))
+		row := int(math.Floor((y - pos.ULY) / pos.YDim))
+
+		if row < 0 || col < 0 || row >= pos.Rows || col >= pos.Cols {
+			return PixelPosition{}, false
+		}
+
+		return PixelPosition{Resolution: resolution, Row: row, Col: col}, true
+	}
+
+	return PixelPosition{}, false
+}
+
+// Get the granule level metadata from the metadata file at a specific path in Google Cloud Storage
+func (s *Server) getTileMetadata(path string) (*TileMetadata, error) {
+	pathMetadata, checksum, err := s.getMetadataPath(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := downloadVerified(pathMetadata, checksum)
 
 	if err != nil {
-		return 0, 0, 0, err
+		return nil, err
 	}
 
-	resp, err := http.Get(pathMetadata)
+	return parseTileMetadata(data)
+}
+
+// Get the granule level metadata as the result of a GranuleRequest
+func (s *Server) getTileResult(path string) GranuleResult {
+	tile, err := s.getTileMetadata(path)
+
+	return GranuleResult{tile: tile, err: err}
+}
+
+// Get the product level metadata from the metadata file at a specific path in Google Cloud Storage
+func (s *Server) getProductMetadata(path string) (*ProductMetadata, error) {
+	pathMetadata, checksum, err := s.getMetadataPath(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := downloadVerified(pathMetadata, checksum)
 
 	if err != nil {
-		return 0, 0, 0, err
+		return nil, err
+	}
+
+	return parseProductMetadata(data)
+}
+
+// Get the product level metadata as the result of a GranuleRequest
+func (s *Server) getProductResult(path string) GranuleResult {
+	product, err := s.getProductMetadata(path)
+
+	return GranuleResult{product: product, err: err}
+}
+
+// Get the tile metadata of a granule, the result is cached
+func (s *Server) getGranuleTile(g Granule) (*TileMetadata, error) {
+	key := metadataKey(g.GranuleID, "tile")
+
+	var tile TileMetadata
+
+	if s.metadata.get(key, &tile) {
+		return &tile, nil
 	}
 
-	defer resp.Body.Close()
+	res := s.getTileResult(g.tileMetadataPath())
 
-	data, err := ioutil.ReadAll(resp.Body)
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	s.metadata.put(key, res.tile)
+
+	return res.tile, nil
+}
+
+// Get the product metadata of the product of a granule, the result is cached
+func (s *Server) getGranuleProduct(g Granule) (*ProductMetadata, error) {
+	key := metadataKey(g.GranuleID, "product")
 
-	var v SpectralInformation
+	var product ProductMetadata
 
-	err = xml.Unmarshal(data, &v)
+	if s.metadata.get(key, &product) {
+		return &product, nil
+	}
+
+	res := s.getProductResult(g.productMetadataPath())
+
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	s.metadata.put(key, res.product)
+
+	return res.product, nil
+}
+
+// Get the tile metadata of every granule, the metadata missing from the cache is fetched using the worker pool
+func (s *Server) getTiles(granules []Granule) ([]*TileMetadata, error) {
+	tiles := make([]*TileMetadata, len(granules))
+	missing := make([]Granule, 0)
+	positions := make([]int, 0)
+
+	for i, g := range granules {
+		var tile TileMetadata
+
+		if s.metadata.get(metadataKey(g.GranuleID, "tile"), &tile) {
+			tiles[i] = &tile
+			continue
+		}
+
+		missing = append(missing, g)
+		positions = append(positions, i)
+	}
+
+	if len(missing) == 0 {
+		return tiles, nil
+	}
+
+	results, err := s.performGranules(missing, Granule.tileMetadataPath, s.getTileResult)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for j, res := range results {
+		tiles[positions[j]] = res.tile
+		s.metadata.put(metadataKey(missing[j].GranuleID, "tile"), res.tile)
+	}
+
+	return tiles, nil
+}
+
+// Get the product metadata of every granule, the metadata missing from the cache is fetched using the worker pool
+func (s *Server) getProducts(granules []Granule) ([]*ProductMetadata, error) {
+	products := make([]*ProductMetadata, len(granules))
+	missing := make([]Granule, 0)
+	positions := make([]int, 0)
+
+	for i, g := range granules {
+		var product ProductMetadata
+
+		if s.metadata.get(metadataKey(g.GranuleID, "product"), &product) {
+			products[i] = &product
+			continue
+		}
+
+		missing = append(missing, g)
+		positions = append(positions, i)
+	}
+
+	if len(missing) == 0 {
+		return products, nil
+	}
+
+	results, err := s.performGranules(missing, Granule.productMetadataPath, s.getProductResult)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for j, res := range results {
+		products[positions[j]] = res.product
+		s.metadata.put(metadataKey(missing[j].GranuleID, "product"), res.product)
+	}
+
+	return products, nil
+}
+
+// Get the media link of a metadata file
+// The checksum of the file in the manifest of its product is returned to verify its download.
+// When the file does not exist by its expected name, the top level of its directory is searched for another metadata file.
+func (s *Server) getMetadataPath(filePath string) (string, FileChecksum, error) {
+
+	ctx := context.Background()
+
+	objAttrs, err := s.bucket.Object(filePath).Attrs(ctx)
+
+	if err == storage.ErrObjectNotExist {
+		objAttrs, err = s.findMetadataFile(path.Dir(filePath) + "/")
+	}
+
+	if err != nil {
+		return "", FileChecksum{}, err
+	}
 
-	fmt.Println(v.bands)
+	checksum, err := s.fileChecksum(objAttrs)
 
 	if err != nil {
-		return 0, 0, 0, nil
+		return "", FileChecksum{}, err
 	}
 
-	return 0, 0, 0, nil
+	return objAttrs.MediaLink, checksum, nil
 }
 
-func getMetadataPath(path string) (string, error) {
+// Find the metadata file at the top level of a product or granule directory
+// The path must end with a slash, only the top level of the directory is listed.
+func (s *Server) findMetadataFile(dir string) (*storage.ObjectAttrs, error) {
 
 	ctx := context.Background()
 
-	query := &storage.Query{Prefix: path}
+	query := &storage.Query{Prefix: dir, Delimiter: "/"}
 
-	it := StorageBucket.Objects(ctx, query)
+	it := s.bucket.Objects(ctx, query)
 
 	for {
 		objAttrs, err := it.Next()
@@ -69,15 +624,15 @@ func getMetadataPath(path string) (string, error) {
 		}
 
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		_path := strings.TrimPrefix(objAttrs.Name, path)
+		_path := strings.TrimPrefix(objAttrs.Name, dir)
 
 		if !strings.Contains(_path, "/") && strings.HasSuffix(_path, ".xml") && !strings.Contains(_path, "INSPIRE") {
-			return objAttrs.MediaLink, nil
+			return objAttrs, nil
 		}
 	}
 
-	return "", errors.New("Could not find metadata file")
+	return nil, errors.New("Could not find metadata file")
 }